@@ -0,0 +1,231 @@
+package main
+
+/*
+ * Audit log
+ * ---------
+ *
+ * Every mutating CloudControl API call made by the driver (and every wait for a resource to reach the desired state)
+ * is appended as a JSON line to "audit.log" in the machine's store folder.
+ *
+ * Use "docker-machine-driver-ddcloud timeline <machineDir>" to print it as a timeline.
+ */
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// The name of the audit log file (in the machine's store folder).
+const auditLogFileName = "audit.log"
+
+// Audit record results.
+const (
+	auditResultSuccess = "success"
+	auditResultError   = "error"
+)
+
+// The Ids of resources involved in an audited operation (keyed by resource type, e.g. "server").
+type auditResources map[string]string
+
+// An audit log entry for a CloudControl operation.
+type auditRecord struct {
+	// The time at which the operation started.
+	Timestamp time.Time `json:"timestamp"`
+
+	// The name of the operation (e.g. "DeployServer").
+	Operation string `json:"operation"`
+
+	// The Ids of resources involved in the operation.
+	Resources auditResources `json:"resources,omitempty"`
+
+	// The operation's duration (in milliseconds).
+	DurationMS int64 `json:"duration_ms"`
+
+	// The operation's result ("success" or "error").
+	Result string `json:"result"`
+
+	// The error (if any) returned by the operation.
+	Error string `json:"error,omitempty"`
+
+	// The CloudControl request Id (if reported by CloudControl).
+	//
+	// This is only available for failed operations; the CloudControl API client discards the responses to successful requests
+	// (only returning the Ids of the resources they affect), so their request Ids cannot be recorded.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Perform a CloudControl operation, and record it in the audit log.
+//
+// The operation can add the Ids of resources that it creates to resources (they are recorded once the operation is complete).
+func (driver *Driver) audit(operation string, resources auditResources, action func() error) error {
+	startTime := time.Now()
+	err := action()
+
+	record := auditRecord{
+		Timestamp:  startTime.UTC(),
+		Operation:  operation,
+		Resources:  resources,
+		DurationMS: int64(time.Since(startTime) / time.Millisecond),
+		Result:     auditResultSuccess,
+	}
+	if err != nil {
+		record.Result = auditResultError
+		record.Error = err.Error()
+		record.RequestID = getCloudControlRequestID(err)
+	}
+
+	auditErr := driver.writeAuditRecord(record)
+	if auditErr != nil {
+		log.Warnf("Unable to write to audit log for machine '%s': %s", driver.MachineName, auditErr.Error())
+	}
+
+	return err
+}
+
+// Get the CloudControl request Id (if any) from an API error.
+func getCloudControlRequestID(err error) string {
+	apiError, ok := err.(*compute.APIError)
+	if !ok || apiError.Response == nil {
+		return ""
+	}
+
+	return apiError.Response.GetRequestID()
+}
+
+// Get the path of the machine's audit log file (or an empty string if there is nowhere to write it).
+func (driver *Driver) getAuditLogFile() string {
+	if driver.auditLogFile != "" {
+		return driver.auditLogFile
+	}
+
+	if driver.BaseDriver == nil || driver.StorePath == "" || driver.MachineName == "" {
+		return "" // No machine store folder (e.g. when running a standalone sub-command).
+	}
+
+	return driver.ResolveStorePath(auditLogFileName)
+}
+
+// Append a record to the machine's audit log.
+func (driver *Driver) writeAuditRecord(record auditRecord) error {
+	logFile := driver.getAuditLogFile()
+	if logFile == "" {
+		return nil
+	}
+
+	recordData, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	auditLogFile, err := os.OpenFile(
+		logFile,
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY,
+		0600,
+	)
+	if err != nil {
+		return err
+	}
+	defer auditLogFile.Close()
+
+	_, err = auditLogFile.Write(
+		append(recordData, '\n'),
+	)
+
+	return err
+}
+
+// Read the audit log for an existing machine from its Docker Machine store folder.
+func readAuditLog(machineDirectory string) ([]auditRecord, error) {
+	auditLogFile, err := os.Open(
+		filepath.Join(machineDirectory, auditLogFileName),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open audit log: %s", err.Error())
+	}
+	defer auditLogFile.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(auditLogFile)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record auditRecord
+		err = json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid entry on line %d of audit log: %s", lineNumber, err.Error())
+		}
+		records = append(records, record)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Print the audit log for an existing machine as a timeline.
+func printAuditTimeline(machineDirectory string, writer io.Writer) error {
+	records, err := readAuditLog(machineDirectory)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(writer, "No CloudControl operations have been recorded.")
+
+		return nil
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tOFFSET\tOPERATION\tRESOURCES\tDURATION\tRESULT\tREQUEST ID")
+
+	firstTimestamp := records[0].Timestamp
+	for _, record := range records {
+		result := record.Result
+		if record.Error != "" {
+			result += ": " + record.Error
+		}
+
+		fmt.Fprintf(table, "%s\t+%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.Timestamp.Local().Format(time.RFC3339),
+			record.Timestamp.Sub(firstTimestamp).Round(time.Second),
+			record.Operation,
+			formatAuditResources(record.Resources),
+			(time.Duration(record.DurationMS) * time.Millisecond).Round(100*time.Millisecond),
+			result,
+			record.RequestID,
+		)
+	}
+
+	return table.Flush()
+}
+
+// Format audited resource Ids for display ("type=id, type=id").
+func formatAuditResources(resources auditResources) string {
+	resourceTypes := make([]string, 0, len(resources))
+	for resourceType := range resources {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+
+	formattedResources := make([]string, len(resourceTypes))
+	for index, resourceType := range resourceTypes {
+		formattedResources[index] = resourceType + "=" + resources[resourceType]
+	}
+
+	return strings.Join(formattedResources, ", ")
+}
@@ -0,0 +1,163 @@
+package main
+
+/*
+ * SSH bootstrap password
+ * ----------------------
+ *
+ * CloudControl requires an administrator password when deploying a server; if the user doesn't supply one, we generate one
+ * (it is only used for the SSH bootstrap session, and is never persisted).
+ */
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// CloudControl administrator password rules.
+const (
+	// The minimum length of an administrator password.
+	bootstrapPasswordMinLength = 8
+
+	// The maximum length of an administrator password.
+	bootstrapPasswordMaxLength = 64
+
+	// The special characters permitted in an administrator password.
+	bootstrapPasswordSpecialCharacters = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// Character sets used when generating bootstrap passwords.
+//
+// Special characters are restricted to a subset that doesn't need quoting in a shell.
+const (
+	generatedPasswordLength            = 24
+	generatedPasswordUpper             = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	generatedPasswordLower             = "abcdefghijkmnopqrstuvwxyz"
+	generatedPasswordDigits            = "23456789"
+	generatedPasswordSpecialCharacters = "@%+=_-"
+)
+
+// Get the password used to bootstrap SSH (either the one supplied by the user or the one generated by the driver).
+func (driver *Driver) getSSHBootstrapPassword() (string, error) {
+	if driver.SSHBootstrapPassword != "" {
+		return driver.decryptSecret(driver.SSHBootstrapPassword)
+	}
+
+	return driver.generatedBootstrapPassword, nil
+}
+
+// Ensure that an SSH bootstrap password is available, generating one if required.
+func (driver *Driver) ensureSSHBootstrapPassword() error {
+	if driver.SSHBootstrapPassword != "" {
+		password, err := driver.decryptSecret(driver.SSHBootstrapPassword)
+		if err != nil {
+			return err
+		}
+
+		// An existing server's root password may predate CloudControl's complexity rules.
+		if driver.isAdoptingExistingServer() {
+			return nil
+		}
+
+		return validateBootstrapPassword(password)
+	}
+
+	if driver.generatedBootstrapPassword != "" {
+		return nil
+	}
+
+	password, err := generateBootstrapPassword()
+	if err != nil {
+		return err
+	}
+	driver.generatedBootstrapPassword = password
+
+	return nil
+}
+
+// Clear the SSH bootstrap password (once it is no longer required).
+func (driver *Driver) clearSSHBootstrapPassword() {
+	driver.SSHBootstrapPassword = ""
+	driver.generatedBootstrapPassword = ""
+}
+
+// Verify that a password meets CloudControl's complexity rules for administrator passwords.
+func validateBootstrapPassword(password string) error {
+	if len(password) < bootstrapPasswordMinLength || len(password) > bootstrapPasswordMaxLength {
+		return fmt.Errorf("SSH bootstrap password must be between %d and %d characters long",
+			bootstrapPasswordMinLength,
+			bootstrapPasswordMaxLength,
+		)
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, character := range password {
+		switch {
+		case character >= 'A' && character <= 'Z':
+			hasUpper = true
+		case character >= 'a' && character <= 'z':
+			hasLower = true
+		case character >= '0' && character <= '9':
+			hasDigit = true
+		case strings.ContainsRune(bootstrapPasswordSpecialCharacters, character):
+			hasSpecial = true
+		default:
+			return fmt.Errorf("SSH bootstrap password contains an unsupported character ('%c')", character)
+		}
+	}
+
+	if !(hasUpper && hasLower && hasDigit && hasSpecial) {
+		return errors.New("SSH bootstrap password must contain at least one upper-case letter, one lower-case letter, one digit, and one special character")
+	}
+
+	return nil
+}
+
+// Generate a random password that meets CloudControl's complexity rules for administrator passwords.
+func generateBootstrapPassword() (string, error) {
+	characterSets := []string{
+		generatedPasswordUpper,
+		generatedPasswordLower,
+		generatedPasswordDigits,
+		generatedPasswordSpecialCharacters,
+	}
+	allCharacters := strings.Join(characterSets, "")
+
+	// One character from each set, then fill with characters from any set.
+	password := make([]byte, generatedPasswordLength)
+	for index := range password {
+		characters := allCharacters
+		if index < len(characterSets) {
+			characters = characterSets[index]
+		}
+
+		characterIndex, err := randomInt(len(characters))
+		if err != nil {
+			return "", err
+		}
+		password[index] = characters[characterIndex]
+	}
+
+	// Shuffle so the required characters aren't always at the start.
+	for index := len(password) - 1; index > 0; index-- {
+		swapIndex, err := randomInt(index + 1)
+		if err != nil {
+			return "", err
+		}
+		password[index], password[swapIndex] = password[swapIndex], password[index]
+	}
+
+	return string(password), nil
+}
+
+// Generate a cryptographically-secure random integer in the range [0, max).
+func randomInt(max int) (int, error) {
+	value, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(value.Int64()), nil
+}
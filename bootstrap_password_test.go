@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBootstrapPassword(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Password      string
+		ExpectedError string
+	}{
+		{"Valid", "Passw0rd!", ""},
+		{"Minimum length", "Pa55w0r!", ""},
+		{"Maximum length", "Pa5!" + strings.Repeat("x", bootstrapPasswordMaxLength-4), ""},
+		{"Each special character", "Passw0rd" + bootstrapPasswordSpecialCharacters, ""},
+		{"Too short", "Pa5w0r!", "between 8 and 64 characters"},
+		{"Too long", "Pa5!" + strings.Repeat("x", bootstrapPasswordMaxLength-3), "between 8 and 64 characters"},
+		{"Empty", "", "between 8 and 64 characters"},
+		{"No upper-case letter", "passw0rd!", "at least one upper-case letter"},
+		{"No lower-case letter", "PASSW0RD!", "at least one upper-case letter"},
+		{"No digit", "Password!", "at least one upper-case letter"},
+		{"No special character", "Passw0rdX", "at least one upper-case letter"},
+		{"Space", "Passw0rd !", "unsupported character (' ')"},
+		{"Non-ASCII letter", "Passw0rd!é", "unsupported character ('é')"},
+		{"Tab", "Passw0rd!\t", "unsupported character"},
+	}
+
+	for _, testCase := range testCases {
+		err := validateBootstrapPassword(testCase.Password)
+		if testCase.ExpectedError == "" {
+			if err != nil {
+				t.Errorf("%s: password '%s' was rejected: %s", testCase.Name, testCase.Password, err)
+			}
+
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: password '%s' was accepted (expected error containing '%s')", testCase.Name, testCase.Password, testCase.ExpectedError)
+		} else if !strings.Contains(err.Error(), testCase.ExpectedError) {
+			t.Errorf("%s: unexpected error '%s' (expected error containing '%s')", testCase.Name, err, testCase.ExpectedError)
+		}
+	}
+}
+
+func TestGenerateBootstrapPasswordAlwaysValidates(t *testing.T) {
+	for attempt := 0; attempt < 1000; attempt++ {
+		password, err := generateBootstrapPassword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != generatedPasswordLength {
+			t.Fatalf("Generated password '%s' has length %d (expected %d)", password, len(password), generatedPasswordLength)
+		}
+
+		err = validateBootstrapPassword(password)
+		if err != nil {
+			t.Fatalf("Generated password '%s' is invalid: %s", password, err)
+		}
+	}
+}
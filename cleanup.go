@@ -0,0 +1,309 @@
+package main
+
+/*
+ * Machine removal
+ * ---------------
+ *
+ * Removal is best-effort: every cleanup step is attempted (even if earlier steps fail), resources that no longer exist are treated as removed,
+ * and the Ids of resources that could not be removed are kept in the driver's state (so a subsequent "docker-machine rm" only retries those).
+ * Docker Machine does not save the machine's configuration when removal fails, so in that case the driver saves it itself.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/persist"
+)
+
+// Errors encountered while removing a machine's resources.
+type removalErrors struct {
+	failures []string
+}
+
+// Attempt a cleanup step, recording its error (if any).
+func (errs *removalErrors) attempt(description string, step func() error) {
+	err := step()
+	if err != nil {
+		errs.record(description, err)
+	}
+}
+
+// Record the error from a cleanup step.
+func (errs *removalErrors) record(description string, err error) {
+	log.Warnf("Unable to %s: %s", description, err.Error())
+
+	errs.failures = append(errs.failures, fmt.Sprintf("unable to %s (%s)", description, err.Error()))
+}
+
+// Record a failure that prevented a cleanup step from being attempted.
+func (errs *removalErrors) skip(description string, reason string) {
+	log.Warnf("Not attempting to %s (%s).", description, reason)
+
+	errs.failures = append(errs.failures, fmt.Sprintf("did not %s (%s)", description, reason))
+}
+
+// Describe the resources (if any) that remain for the target machine.
+func (driver *Driver) describeRemainingResources() string {
+	var remaining []string
+	addRemaining := func(resourceType string, resourceID string) {
+		if resourceID != "" {
+			remaining = append(remaining, fmt.Sprintf("%s '%s'", resourceType, resourceID))
+		}
+	}
+	addRemaining("server", driver.ServerID)
+	addRemaining("SSH firewall rule", driver.SSHFirewallRuleID)
+	addRemaining("SSH bootstrap firewall rule", driver.SSHBootstrapFirewallRuleID)
+	addRemaining("Docker firewall rule", driver.DockerFirewallRuleID)
+	addRemaining("NAT rule", driver.NATRuleID)
+	addRemaining("public IP block", driver.PublicIPBlockID)
+
+	if len(remaining) == 0 {
+		return "none"
+	}
+
+	return strings.Join(remaining, ", ")
+}
+
+// Combine the errors (if any) encountered while removing the target machine's resources.
+//
+// If any resources remain, the driver's state is saved so that the next "docker-machine rm" only retries those.
+func (driver *Driver) combineRemovalErrors(errs *removalErrors) error {
+	if len(errs.failures) == 0 {
+		return nil
+	}
+
+	err := driver.saveMachineConfig()
+	if err != nil {
+		log.Warnf("Unable to save the remaining resources for machine '%s' (the next removal will retry every step): %s", driver.MachineName, err.Error())
+	}
+
+	return fmt.Errorf("Unable to remove all resources for machine '%s' (remaining resources: %s): %s",
+		driver.MachineName,
+		driver.describeRemainingResources(),
+		strings.Join(errs.failures, "; "),
+	)
+}
+
+// Save the driver's state in the machine's configuration.
+//
+// Docker Machine does not save the machine's configuration when removal fails, so the driver saves it (via the machine store) itself.
+func (driver *Driver) saveMachineConfig() error {
+	if driver.BaseDriver == nil || driver.StorePath == "" || driver.MachineName == "" {
+		return errors.New("no machine store folder has been configured")
+	}
+
+	store := persist.NewFilestore(driver.StorePath, "", "")
+	machine, err := store.Load(driver.MachineName)
+	if err != nil {
+		return err
+	}
+	machine.Driver = driver
+
+	return store.Save(machine)
+}
+
+// Remove the firewall rules, NAT rule, and public IP block (if any) created by the driver for the target server.
+func (driver *Driver) removeNetworkResources(errs *removalErrors) {
+	if driver.isSSHFirewallRuleCreated() {
+		errs.attempt("delete SSH firewall rule", driver.deleteSSHFirewallRule)
+	}
+
+	if driver.isSSHBootstrapFirewallRuleCreated() {
+		errs.attempt("delete SSH bootstrap firewall rule", driver.deleteSSHBootstrapFirewallRule)
+	}
+
+	if driver.isDockerFirewallRuleCreated() {
+		errs.attempt("delete Docker firewall rule", driver.deleteDockerFirewallRule)
+	}
+
+	if driver.isNATRuleCreated() {
+		if driver.ServerAdopted && driver.NATRuleAdopted && !driver.DeleteAdoptedServer {
+			log.Debugf("Not deleting NAT rule '%s' (it was not created by the driver).", driver.NATRuleID)
+
+			driver.NATRuleID = ""
+			driver.NATRuleAdopted = false
+		} else {
+			errs.attempt("delete NAT rule", driver.deleteNATRuleForServer)
+		}
+	}
+
+	if driver.PublicIPBlockID != "" {
+		if driver.isNATRuleCreated() {
+			errs.skip("release public IP block", "NAT rule has not been deleted")
+		} else {
+			errs.attempt("release public IP block", driver.releasePublicIPBlock)
+		}
+	}
+}
+
+// Release the public IP block allocated by the driver (unless its addresses are being used by other NAT rules).
+//
+// Holds the lock for the target network domain (so the block's addresses cannot be claimed by a NAT rule that another driver process is creating).
+func (driver *Driver) releasePublicIPBlock() error {
+	lock, err := driver.lockNetworkDomain()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
+	}
+
+	block, err := client.GetPublicIPBlock(driver.PublicIPBlockID)
+	if err != nil && !compute.IsResourceNotFoundError(err) {
+		return err
+	}
+	if block == nil {
+		log.Debugf("Public IP block '%s' not found; will treat it as already released.", driver.PublicIPBlockID)
+
+		driver.PublicIPBlockID = ""
+
+		return nil
+	}
+
+	inUse, err := driver.isPublicIPBlockInUse(block)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		log.Infof("Not releasing public IP block '%s' (%s/%d); its addresses are being used by other NAT rules.",
+			block.ID,
+			block.BaseIP,
+			block.Size,
+		)
+
+		driver.PublicIPBlockID = ""
+
+		return nil
+	}
+
+	err = driver.audit("RemovePublicIPBlock", auditResources{"publicIPBlock": block.ID}, func() error {
+		return client.RemovePublicIPBlock(block.ID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("Public IP block '%s' not found; will treat it as already released.", block.ID)
+	} else if err != nil {
+		return err
+	}
+
+	log.Debugf("Released public IP block '%s'.", block.ID)
+
+	driver.PublicIPBlockID = ""
+
+	return nil
+}
+
+// Determine whether any NAT rules in the target network domain use addresses from the specified public IP block.
+func (driver *Driver) isPublicIPBlockInUse(block *compute.PublicIPBlock) (bool, error) {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return false, err
+	}
+
+	page := compute.DefaultPaging()
+	for {
+		var rules *compute.NATRules
+		rules, err = client.ListNATRules(block.NetworkDomainID, page)
+		if err != nil {
+			return false, err
+		}
+		if rules.IsEmpty() {
+			break // We're done
+		}
+
+		for _, rule := range rules.Rules {
+			var inBlock bool
+			inBlock, err = isAddressInPublicIPBlock(rule.ExternalIPAddress, block)
+			if err != nil || inBlock {
+				return inBlock, err
+			}
+		}
+
+		page.Next()
+	}
+
+	return false, nil
+}
+
+// Determine whether an IPv4 address is one of the addresses in the specified public IP block.
+func isAddressInPublicIPBlock(address string, block *compute.PublicIPBlock) (bool, error) {
+	baseIP := net.ParseIP(block.BaseIP).To4()
+	if baseIP == nil {
+		return false, fmt.Errorf("Public IP block '%s' has invalid base address '%s'", block.ID, block.BaseIP)
+	}
+	firstAddress := binary.BigEndian.Uint32(baseIP)
+	lastAddress := firstAddress + uint32(block.Size) - 1
+
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return false, nil
+	}
+
+	ipAddress := binary.BigEndian.Uint32(ip)
+
+	return ipAddress >= firstAddress && ipAddress <= lastAddress, nil
+}
+
+// Stop (or, failing that, power off) the target server prior to its removal.
+//
+// Returns false if the server is still running.
+func (driver *Driver) stopServerForRemoval(server *compute.Server, errs *removalErrors) bool {
+	if !server.Started {
+		return true
+	}
+
+	err := driver.stopServer()
+	if err != nil {
+		log.Warnf("Unable to stop server '%s' (%s); powering it off instead.", driver.MachineName, err.Error())
+
+		err = driver.powerOffServer()
+	}
+	if err != nil {
+		errs.record("stop server", err)
+
+		return false
+	}
+
+	return true
+}
+
+// Delete the target server.
+func (driver *Driver) deleteServer() error {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
+	}
+
+	err = driver.audit("DeleteServer", auditResources{"server": driver.ServerID}, func() error {
+		return client.DeleteServer(driver.ServerID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("Server '%s' not found; will treat it as already deleted.", driver.ServerID)
+
+		driver.ServerID = ""
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = driver.audit("WaitForDelete", auditResources{"server": driver.ServerID}, func() error {
+		return client.WaitForDelete(compute.ResourceTypeServer, driver.ServerID, serverDeleteTimeout)
+	})
+	if err != nil {
+		return err
+	}
+
+	driver.ServerID = "" // Record deletion.
+
+	return nil
+}
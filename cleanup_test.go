@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/persist"
+	"github.com/docker/machine/libmachine/version"
+)
+
+func TestRemoveSavesRemainingResourcesWhenRemovalFails(t *testing.T) {
+	fake := newFakeCloudControl(t)
+	fake.PublicIPBlocks = []compute.PublicIPBlock{
+		{ID: "public-ip-block-1", NetworkDomainID: "network-domain-1", BaseIP: "203.0.113.1", Size: 2, State: compute.ResourceStatusNormal},
+	}
+	fake.NATRules = []compute.NATRule{
+		{ID: "nat-rule-1", NetworkDomainID: "network-domain-1", InternalIPAddress: "10.0.0.10", ExternalIPAddress: "203.0.113.1"},
+	}
+
+	driver := fake.newDriver(t, "test-machine", "")
+	driver.NetworkDomainID = "network-domain-1"
+	driver.PrivateIPAddress = "10.0.0.10"
+	driver.IPAddress = "203.0.113.1"
+	driver.NATRuleID = "nat-rule-1"
+	driver.PublicIPBlockID = "public-ip-block-1"
+	driver.SSHFirewallRuleID = "firewall-rule-1" // The fake API does not support firewall rules, so deleting this one will fail.
+
+	// The machine's configuration, as saved by Docker Machine when the machine was created.
+	store := persist.NewFilestore(driver.StorePath, "", "")
+	err := store.Save(&host.Host{
+		ConfigVersion: version.ConfigVersion,
+		Driver:        driver,
+		DriverName:    driver.DriverName(),
+		HostOptions: &host.Options{
+			AuthOptions: &auth.Options{StorePath: driver.ResolveStorePath(".")},
+		},
+		Name: driver.MachineName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.Remove()
+	if err == nil {
+		t.Fatal("Removal succeeded (expected deletion of the SSH firewall rule to fail)")
+	}
+	if len(fake.NATRules) != 0 || len(fake.PublicIPBlocks) != 0 {
+		t.Fatalf("Removal did not delete the NAT rule and release the public IP block (%d NAT rules and %d blocks remain)", len(fake.NATRules), len(fake.PublicIPBlocks))
+	}
+
+	configData, err := ioutil.ReadFile(driver.ResolveStorePath("config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Driver *Driver
+	}
+	err = json.Unmarshal(configData, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedDriver := config.Driver
+	if savedDriver.SSHFirewallRuleID != "firewall-rule-1" {
+		t.Errorf("Saved SSH firewall rule Id is '%s' (expected 'firewall-rule-1', which could not be deleted)", savedDriver.SSHFirewallRuleID)
+	}
+	if savedDriver.NATRuleID != "" || savedDriver.PublicIPBlockID != "" {
+		t.Errorf("Saved configuration still references deleted resources (NAT rule '%s', public IP block '%s')", savedDriver.NATRuleID, savedDriver.PublicIPBlockID)
+	}
+	if savedDriver.IPAddress != driver.PrivateIPAddress {
+		t.Errorf("Saved IP address is '%s' (expected private IP address '%s')", savedDriver.IPAddress, driver.PrivateIPAddress)
+	}
+}
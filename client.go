@@ -6,8 +6,14 @@ package main
  */
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -24,6 +30,9 @@ const (
 	clientRetryPeriod = 5 * time.Second
 )
 
+// The CloudControl response code indicating that no public IP addresses are available (e.g. another client claimed the last one).
+const responseCodeNoIPAddressAvailable = "NO_IP_ADDRESS_AVAILABLE"
+
 // Timeouts
 const (
 	// CloudControl server deployment timeout.
@@ -35,8 +44,23 @@ const (
 	// CloudControl server startup timeout.
 	serverStartTimeout = 3 * time.Minute
 
-	// CloudControl server shutdown timeout.
-	serverStopTimeout = 3 * time.Minute
+	// The timeout when waiting for an operation already in progress on a server to complete.
+	serverPendingChangeTimeout = 10 * time.Minute
+
+	// The maximum number of operations already in progress on a server to wait for before changing its power state.
+	serverPendingOperationMaxWaits = 3
+
+	// The maximum number of attempts to create a NAT rule (if it conflicts with other changes in the same network domain).
+	natRuleMaxAttempts = 5
+
+	// The default period of time between attempts to create a NAT rule.
+	defaultNATRuleRetryPeriod = 10 * time.Second
+
+	// CloudControl server reboot timeout.
+	serverRestartTimeout = 5 * time.Minute
+
+	// The period of time between attempts to power off a server that is busy (e.g. still trying to shut down).
+	serverPowerOffRetryPeriod = 10 * time.Second
 
 	// CloudControl server power-off timeout.
 	serverPowerOffTimeout = 2 * time.Minute
@@ -49,34 +73,67 @@ func (driver *Driver) getCloudControlClient() (client *compute.Client, err error
 		return
 	}
 
-	if driver.CloudControlUser == "" {
+	// Encrypt secrets persisted by older versions of the driver (the updated configuration is saved by Docker Machine).
+	err = driver.migrateSecrets()
+	if err != nil {
+		return
+	}
+
+	var credentials *credentialProfile
+	credentials, err = driver.getCloudControlCredentials()
+	if err != nil {
+		return
+	}
+
+	if credentials.User == "" {
 		err = errors.New("Cannot connect to CloudControl API (user name has not been configured)")
 
 		return
 	}
 
-	if driver.CloudControlPassword == "" {
+	if credentials.Password == "" {
 		err = errors.New("Cannot connect to CloudControl API (password has not been configured)")
 
 		return
 	}
 
-	if driver.CloudControlRegion != "" {
-		client = compute.NewClient(driver.CloudControlRegion, driver.CloudControlUser, driver.CloudControlPassword)
-	} else if driver.CloudControlEndPointURI != "" {
-		client = compute.NewClientWithBaseAddress(driver.CloudControlEndPointURI, driver.CloudControlUser, driver.CloudControlPassword)
+	var transport *http.Transport
+	transport, err = driver.getHTTPTransport()
+	if err != nil {
+		return
+	}
+
+	var baseAddress string
+	if credentials.Region != "" {
+		baseAddress = getCloudControlRegionBaseAddress(credentials.Region)
+	} else if credentials.EndPointURI != "" {
+		baseAddress = credentials.EndPointURI
 	} else {
-		err = errors.New("Cannot connect to CloudControl API (neither region nor custom end-point URI have been configured)")
+		err = errors.New("Cannot connect to CloudControl API (neither region, data centre, nor custom end-point URI have been configured)")
 
 		return
 	}
+
+	// The CloudControl API client always uses the default HTTP transport; only its requests (to this end-point, as this user) use the driver's transport.
+	err = registerCloudControlTransport(baseAddress, credentials.User, transport)
+	if err != nil {
+		return
+	}
+
+	client = compute.NewClientWithBaseAddress(baseAddress, credentials.User, credentials.Password)
 	client.ConfigureRetry(clientMaxRetry, clientRetryPeriod)
 
 	driver.client = client
+	driver.cloudControlBaseAddress = baseAddress
 
 	return
 }
 
+// Get the base address of the CloudControl API end-point for the specified region (as used by compute.NewClient).
+func getCloudControlRegionBaseAddress(region string) string {
+	return fmt.Sprintf("https://api-%s.dimensiondata.com", region)
+}
+
 // Determine whether the target server has been created.
 func (driver *Driver) isServerCreated() bool {
 	return driver.ServerID != ""
@@ -159,7 +216,7 @@ func (driver *Driver) resolveVLAN() error {
 	driver.VLANID = ""
 
 	if driver.VLANName == "" {
-		return errors.New("VLAN name has not been configured")
+		return errors.New("VLAN (--ddcloud-vlan) has not been specified (required unless --ddcloud-private-ipv4 or --ddcloud-existing-server is specified)")
 	}
 
 	var err error
@@ -270,14 +327,25 @@ func (driver *Driver) deployServer() (*compute.Server, error) {
 		return nil, err
 	}
 
-	driver.ServerID, err = client.DeployServer(serverConfiguration)
+	resources := auditResources{"networkDomain": driver.NetworkDomainID}
+	err = driver.audit("DeployServer", resources, func() (deployErr error) {
+		driver.ServerID, deployErr = client.DeployServer(serverConfiguration)
+		resources["server"] = driver.ServerID
+
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugf("Deploying server '%s' ('%s')...", driver.ServerID, driver.MachineName)
 
-	resource, err := client.WaitForDeploy(compute.ResourceTypeServer, driver.ServerID, serverCreateTimeout)
+	var resource compute.Resource
+	err = driver.audit("WaitForDeploy", auditResources{"server": driver.ServerID}, func() (waitErr error) {
+		resource, waitErr = client.WaitForDeploy(compute.ResourceTypeServer, driver.ServerID, serverCreateTimeout)
+
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -304,6 +372,17 @@ func (driver *Driver) buildDeploymentConfiguration() (deploymentConfiguration co
 		return
 	}
 
+	var administratorPassword string
+	administratorPassword, err = driver.getSSHBootstrapPassword()
+	if err != nil {
+		return
+	}
+	if administratorPassword == "" {
+		err = errors.New("SSH bootstrap password has not been configured")
+
+		return
+	}
+
 	// Specify private IPv4 address or VLAN Id.
 	var (
 		vlanID             *string
@@ -318,7 +397,7 @@ func (driver *Driver) buildDeploymentConfiguration() (deploymentConfiguration co
 	deploymentConfiguration = compute.ServerDeploymentConfiguration{
 		Name:                  driver.MachineName,
 		Description:           fmt.Sprintf("%s (created by Docker Machine).", driver.MachineName),
-		AdministratorPassword: driver.SSHBootstrapPassword,
+		AdministratorPassword: administratorPassword,
 
 		Network: compute.VirtualMachineNetwork{
 			NetworkDomainID: driver.NetworkDomainID,
@@ -351,16 +430,35 @@ func (driver *Driver) buildDeploymentConfiguration() (deploymentConfiguration co
 
 // Start the target server.
 func (driver *Driver) startServer() error {
-	server, err := driver.getServer()
+	required, err := driver.prepareServerPowerChange(true)
+	if err != nil || !required {
+		return err
+	}
+
+	client, err := driver.getCloudControlClient()
 	if err != nil {
 		return err
 	}
-	if server == nil {
-		return fmt.Errorf("server '%s' not found", driver.ServerID)
+
+	err = driver.audit("StartServer", auditResources{"server": driver.ServerID}, func() error {
+		return client.StartServer(driver.ServerID)
+	})
+	if err != nil {
+		return err
 	}
 
-	if !server.Started {
-		return nil
+	return driver.audit("WaitForChange", auditResources{"server": driver.ServerID}, func() error {
+		_, waitErr := client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Start server", serverStartTimeout)
+
+		return waitErr
+	})
+}
+
+// Stop the target server.
+func (driver *Driver) stopServer() error {
+	required, err := driver.prepareServerPowerChange(false)
+	if err != nil || !required {
+		return err
 	}
 
 	client, err := driver.getCloudControlClient()
@@ -368,47 +466,182 @@ func (driver *Driver) startServer() error {
 		return err
 	}
 
-	err = client.StartServer(driver.ServerID)
+	err = driver.audit("ShutdownServer", auditResources{"server": driver.ServerID}, func() error {
+		return client.ShutdownServer(driver.ServerID)
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Start server", serverStartTimeout)
+	err = driver.audit("WaitForChange", auditResources{"server": driver.ServerID}, func() error {
+		_, waitErr := client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Shut down server", driver.getStopGracePeriod())
+
+		return waitErr
+	})
+	if isWaitTimeoutError(err) {
+		log.Warnf("Server '%s' did not shut down gracefully within %s; powering it off instead.",
+			driver.MachineName,
+			driver.getStopGracePeriod(),
+		)
+
+		return driver.powerOffServerWhenNotBusy()
+	}
 
 	return err
 }
 
-// Stop the target server.
-func (driver *Driver) stopServer() error {
-	server, err := driver.getServer()
+// Determine whether an error returned by one of the CloudControl API client's WaitForXXX functions indicates that the wait timed out.
+//
+// The client does not use a distinct error type for timeouts, so they can only be identified by their message.
+func isWaitTimeoutError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "Timed out after waiting ")
+}
+
+// Get the time to wait for a graceful shutdown before powering off the target server.
+func (driver *Driver) getStopGracePeriod() time.Duration {
+	// Machines created by older versions of the driver always used the default grace period.
+	if driver.StopGracePeriod == 0 {
+		return DefaultStopGracePeriod * time.Second
+	}
+
+	return time.Duration(driver.StopGracePeriod) * time.Second
+}
+
+// Power off the target server, retrying while it is busy (e.g. still trying to shut down).
+func (driver *Driver) powerOffServerWhenNotBusy() error {
+	deadline := time.Now().Add(serverPowerOffTimeout)
+	for {
+		err := driver.requestPowerOff()
+		if err == nil || !compute.IsResourceBusyError(err) || time.Now().Add(serverPowerOffRetryPeriod).After(deadline) {
+			return err
+		}
+
+		log.Debugf("Server '%s' is busy; will retry power-off in %s...", driver.MachineName, serverPowerOffRetryPeriod)
+		time.Sleep(serverPowerOffRetryPeriod)
+	}
+}
+
+// Reboot the target server.
+func (driver *Driver) rebootServer() error {
+	required, err := driver.prepareServerPowerChange(true)
 	if err != nil {
 		return err
 	}
-	if server == nil {
-		return fmt.Errorf("server '%s' not found", driver.ServerID)
+	if required {
+		return driver.startServer() // Server is stopped.
 	}
 
-	if !server.Started {
-		return nil
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
 	}
 
-	client, err := driver.getCloudControlClient()
+	err = driver.audit("RebootServer", auditResources{"server": driver.ServerID}, func() error {
+		return driver.requestServerReboot(client)
+	})
 	if err != nil {
 		return err
 	}
 
-	err = client.ShutdownServer(driver.ServerID)
+	return driver.audit("WaitForChange", auditResources{"server": driver.ServerID}, func() error {
+		_, waitErr := client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Reboot server", serverRestartTimeout)
+
+		return waitErr
+	})
+}
+
+// Request that CloudControl reboot the target server.
+//
+// The CloudControl API client does not support the rebootServer operation, so the request is made directly.
+func (driver *Driver) requestServerReboot(client *compute.Client) error {
+	requestBody := map[string]string{
+		"id": driver.ServerID,
+	}
+	statusCode, responseBody, err := driver.executeCloudControlRequest(client, http.MethodPost, "2.2", "server/rebootServer", requestBody)
 	if err != nil {
 		return err
 	}
 
-	_, err = client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Shut down server", serverStopTimeout)
+	apiResponse := &compute.APIResponseV2{}
+	err = json.Unmarshal(responseBody, apiResponse)
+	if err != nil {
+		return fmt.Errorf("Request to reboot server failed with status code %d (unable to read response: %s)", statusCode, err.Error())
+	}
+	if apiResponse.ResponseCode != compute.ResponseCodeInProgress {
+		return apiResponse.ToError("Request to reboot server failed with unexpected status code %d (%s): %s", statusCode, apiResponse.ResponseCode, apiResponse.Message)
+	}
 
-	return err
+	return nil
 }
 
-// Stop the target server.
+// Execute a request against the CloudControl API directly (for operations and fields not supported by the CloudControl API client), using the client's end-point and credentials.
+//
+// apiVersion is the version of the CloudControl API (e.g. "2.2"), and relativeURI is relative to the organisation (e.g. "server/rebootServer").
+func (driver *Driver) executeCloudControlRequest(client *compute.Client, method string, apiVersion string, relativeURI string, requestBody interface{}) (statusCode int, responseBody []byte, err error) {
+	account, err := client.GetAccount()
+	if err != nil {
+		return
+	}
+
+	credentials, err := driver.getCloudControlCredentials()
+	if err != nil {
+		return
+	}
+
+	var requestBodyReader io.Reader
+	if requestBody != nil {
+		var requestBodyData []byte
+		requestBodyData, err = json.Marshal(requestBody)
+		if err != nil {
+			return
+		}
+		requestBodyReader = bytes.NewReader(requestBodyData)
+	}
+
+	requestURI := fmt.Sprintf("%s/caas/%s/%s/%s",
+		strings.TrimSuffix(driver.cloudControlBaseAddress, "/"),
+		apiVersion,
+		url.PathEscape(account.OrganizationID),
+		relativeURI,
+	)
+	request, err := http.NewRequest(method, requestURI, requestBodyReader)
+	if err != nil {
+		return
+	}
+	request.SetBasicAuth(credentials.User, credentials.Password)
+	request.Header.Set("Accept", "application/json")
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient, err := driver.getHTTPClient()
+	if err != nil {
+		return
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	statusCode = response.StatusCode
+	responseBody, err = ioutil.ReadAll(response.Body)
+
+	return
+}
+
+// Power off the target server (hard shutdown).
 func (driver *Driver) powerOffServer() error {
+	required, err := driver.prepareServerPowerChange(false)
+	if err != nil || !required {
+		return err
+	}
+
+	return driver.requestPowerOff()
+}
+
+// Power off the target server without waiting for operations in progress (if any) to complete.
+func (driver *Driver) requestPowerOff() error {
 	server, err := driver.getServer()
 	if err != nil {
 		return err
@@ -416,7 +649,6 @@ func (driver *Driver) powerOffServer() error {
 	if server == nil {
 		return fmt.Errorf("server '%s' not found", driver.ServerID)
 	}
-
 	if !server.Started {
 		return nil
 	}
@@ -426,14 +658,18 @@ func (driver *Driver) powerOffServer() error {
 		return err
 	}
 
-	err = client.PowerOffServer(driver.ServerID)
+	err = driver.audit("PowerOffServer", auditResources{"server": driver.ServerID}, func() error {
+		return client.PowerOffServer(driver.ServerID)
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Power off server", serverPowerOffTimeout)
+	return driver.audit("WaitForChange", auditResources{"server": driver.ServerID}, func() error {
+		_, waitErr := client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Power off server", serverPowerOffTimeout)
 
-	return err
+		return waitErr
+	})
 }
 
 // Has a NAT rule been created for the server?
@@ -442,6 +678,8 @@ func (driver *Driver) isNATRuleCreated() bool {
 }
 
 // Create a NAT rule to expose the server.
+//
+// Public IP allocation and NAT rule creation are serialised (per network domain) across concurrent driver processes, and retried if they conflict with other changes.
 func (driver *Driver) createNATRuleForServer() error {
 	if !driver.isServerCreated() {
 		return fmt.Errorf("Server '%s' has not been created", driver.MachineName)
@@ -453,35 +691,21 @@ func (driver *Driver) createNATRuleForServer() error {
 
 	log.Debugf("Creating NAT rule for server '%s' ('%s')...", driver.MachineName, driver.PrivateIPAddress)
 
-	natRule, err := driver.getExistingNATRuleByInternalIP(driver.PrivateIPAddress)
-	if natRule == nil {
-		err = driver.ensurePublicIPAvailable()
-		if err != nil {
-			return err
-		}
-
-		client, err := driver.getCloudControlClient()
-		if err != nil {
-			return err
-		}
-
-		driver.NATRuleID, err = client.AddNATRule(driver.NetworkDomainID, driver.PrivateIPAddress, nil)
-		if err != nil {
-			return err
-		}
-		natRule, err = client.GetNATRule(driver.NATRuleID)
-		if err != nil {
-			return err
-		}
-		if natRule == nil {
-			return fmt.Errorf("Failed to retrieve newly-created NAT rule '%s' for server '%s'", driver.NATRuleID, driver.MachineName)
+	var (
+		natRule *compute.NATRule
+		err     error
+	)
+	for attempt := 1; ; attempt++ {
+		natRule, err = driver.createOrAdoptNATRule()
+		if err == nil || !isNATRuleConflictError(err) || attempt == natRuleMaxAttempts {
+			break
 		}
 
-		log.Debugf("Created NAT rule '%s' for server '%s'", driver.NATRuleID)
-	} else {
-		driver.NATRuleID = natRule.ID
-
-		log.Debugf("NAT rule already exists (Id = '%s').", driver.NATRuleID)
+		log.Debugf("Conflict while creating NAT rule for server '%s' (%s); will retry in %s...", driver.MachineName, err.Error(), driver.getNATRuleRetryPeriod())
+		time.Sleep(driver.getNATRuleRetryPeriod())
+	}
+	if err != nil {
+		return err
 	}
 
 	driver.IPAddress = natRule.ExternalIPAddress
@@ -496,14 +720,81 @@ func (driver *Driver) createNATRuleForServer() error {
 	return nil
 }
 
-// Delete the the server's NAT rule (if any).
-func (driver *Driver) deleteNATRuleForServer() error {
-	if !driver.isServerCreated() {
-		return fmt.Errorf("Server '%s' has not been created", driver.MachineName)
+// Get the period of time between attempts to create a NAT rule.
+func (driver *Driver) getNATRuleRetryPeriod() time.Duration {
+	if driver.natRuleRetryPeriod == 0 {
+		return defaultNATRuleRetryPeriod
+	}
+
+	return driver.natRuleRetryPeriod
+}
+
+// Create a NAT rule for the server (or adopt the existing one, if any) while holding the lock for the target network domain.
+func (driver *Driver) createOrAdoptNATRule() (*compute.NATRule, error) {
+	lock, err := driver.lockNetworkDomain()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	natRule, err := driver.getExistingNATRuleByInternalIP(driver.PrivateIPAddress)
+	if err != nil {
+		return nil, err
 	}
+	if natRule != nil {
+		driver.NATRuleID = natRule.ID
+		driver.NATRuleAdopted = true
 
+		log.Debugf("NAT rule already exists (Id = '%s').", driver.NATRuleID)
+
+		return natRule, nil
+	}
+
+	err = driver.ensurePublicIPAvailable()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var natRuleID string
+	resources := auditResources{"networkDomain": driver.NetworkDomainID, "server": driver.ServerID}
+	err = driver.audit("AddNATRule", resources, func() (addErr error) {
+		natRuleID, addErr = client.AddNATRule(driver.NetworkDomainID, driver.PrivateIPAddress, nil)
+		resources["natRule"] = natRuleID
+
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	driver.NATRuleID = natRuleID
+
+	natRule, err = client.GetNATRule(driver.NATRuleID)
+	if err != nil {
+		return nil, err
+	}
+	if natRule == nil {
+		return nil, fmt.Errorf("Failed to retrieve newly-created NAT rule '%s' for server '%s'", driver.NATRuleID, driver.MachineName)
+	}
+
+	log.Debugf("Created NAT rule '%s' for server '%s'", driver.NATRuleID, driver.MachineName)
+
+	return natRule, nil
+}
+
+// Determine whether an error from NAT rule creation indicates a conflict with another change (e.g. by another client) in the same network domain.
+func isNATRuleConflictError(err error) bool {
+	return compute.IsResourceBusyError(err) || compute.IsAPIErrorCode(err, responseCodeNoIPAddressAvailable)
+}
+
+// Delete the the server's NAT rule (if any).
+func (driver *Driver) deleteNATRuleForServer() error {
 	if !driver.isNATRuleCreated() {
-		log.Debugf("Not deleting NAT rule for server '%s' (no NAT rule was created for it).")
+		log.Debugf("Not deleting NAT rule for server '%s' (no NAT rule was created for it).", driver.MachineName)
 
 		return nil
 	}
@@ -520,21 +811,26 @@ func (driver *Driver) deleteNATRuleForServer() error {
 		return err
 	}
 	if natRule == nil {
-		log.Debugf("NAT rule '%s' not found; will treat it as already deleted.")
+		log.Debugf("NAT rule '%s' not found; will treat it as already deleted.", driver.NATRuleID)
 
 		driver.NATRuleID = ""
 
 		return nil
 	}
 
-	err = client.DeleteNATRule(driver.NATRuleID)
-	if err != nil {
+	err = driver.audit("DeleteNATRule", auditResources{"natRule": driver.NATRuleID}, func() error {
+		return client.DeleteNATRule(driver.NATRuleID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("NAT rule '%s' not found; will treat it as already deleted.", driver.NATRuleID)
+	} else if err != nil {
 		return err
 	}
 
 	log.Debugf("Deleted NAT rule '%s'.", driver.NATRuleID)
 
 	driver.NATRuleID = ""
+	driver.NATRuleAdopted = false
 	driver.IPAddress = driver.PrivateIPAddress
 
 	return nil
@@ -595,11 +891,20 @@ func (driver *Driver) ensurePublicIPAvailable() error {
 	if len(availableIPs) == 0 {
 		log.Debugf("There are no available public IPs in network domain '%s'; a new block of public IPs will be allocated.", driver.NetworkDomainID)
 
-		blockID, err := client.AddPublicIPBlock(driver.NetworkDomainID)
+		var blockID string
+		resources := auditResources{"networkDomain": driver.NetworkDomainID}
+		err = driver.audit("AddPublicIPBlock", resources, func() (addErr error) {
+			blockID, addErr = client.AddPublicIPBlock(driver.NetworkDomainID)
+			resources["publicIPBlock"] = blockID
+
+			return
+		})
 		if err != nil {
 			return err
 		}
 
+		driver.PublicIPBlockID = blockID
+
 		log.Debugf("Allocated new public IP block '%s'.", blockID)
 	}
 
@@ -646,7 +951,7 @@ func (driver *Driver) createSSHFirewallRule() error {
 		return err
 	}
 
-	firewallRuleID, err := client.CreateFirewallRule(ruleConfiguration)
+	firewallRuleID, err := driver.auditCreateFirewallRule(client, ruleConfiguration)
 	if err != nil {
 		return err
 	}
@@ -660,10 +965,6 @@ func (driver *Driver) createSSHFirewallRule() error {
 
 // Delete the firewall rule that enables inbound SSH connections to the target server from the client machine's (external) IP address.
 func (driver *Driver) deleteSSHFirewallRule() error {
-	if !driver.isServerCreated() {
-		return fmt.Errorf("Server '%s' has not been created", driver.MachineName)
-	}
-
 	if !driver.isSSHFirewallRuleCreated() {
 		return fmt.Errorf("SSH firewall rule has not been created for server '%s'", driver.MachineName)
 	}
@@ -678,8 +979,12 @@ func (driver *Driver) deleteSSHFirewallRule() error {
 		return err
 	}
 
-	err = client.DeleteFirewallRule(driver.SSHFirewallRuleID)
-	if err != nil {
+	err = driver.audit("DeleteFirewallRule", auditResources{"firewallRule": driver.SSHFirewallRuleID}, func() error {
+		return client.DeleteFirewallRule(driver.SSHFirewallRuleID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("SSH firewall rule '%s' not found; will treat it as already deleted.", driver.SSHFirewallRuleID)
+	} else if err != nil {
 		return err
 	}
 
@@ -690,6 +995,100 @@ func (driver *Driver) deleteSSHFirewallRule() error {
 	return nil
 }
 
+// Has a firewall rule been created to allow inbound SSH on the default port (while SSH is being bootstrapped) for the server?
+func (driver *Driver) isSSHBootstrapFirewallRuleCreated() bool {
+	return driver.SSHBootstrapFirewallRuleID != ""
+}
+
+// Create a firewall rule to enable inbound SSH connections on the default SSH port (used to bootstrap SSH when a custom SSH port is configured).
+func (driver *Driver) createSSHBootstrapFirewallRule() error {
+	if !driver.isServerCreated() {
+		return fmt.Errorf("Server '%s' has not been created", driver.MachineName)
+	}
+
+	if driver.isSSHBootstrapFirewallRuleCreated() {
+		return fmt.Errorf("SSH bootstrap firewall rule '%s' has already been created for server '%s'", driver.SSHBootstrapFirewallRuleID, driver.MachineName)
+	}
+
+	log.Debugf("Creating SSH bootstrap firewall rule for server '%s' (allow inbound traffic on port %d from '%s' to '%s')...",
+		driver.MachineName,
+		sshDefaultPort,
+		driver.ClientPublicIPAddress,
+		driver.IPAddress,
+	)
+
+	ruleConfiguration := compute.FirewallRuleConfiguration{
+		Name:            driver.buildFirewallRuleName("SSHBootstrap"),
+		NetworkDomainID: driver.NetworkDomainID,
+	}
+	ruleConfiguration.Accept()
+	ruleConfiguration.Enable()
+	ruleConfiguration.IPv4()
+	ruleConfiguration.TCP()
+	ruleConfiguration.MatchSourceAddress(driver.ClientPublicIPAddress)
+	ruleConfiguration.MatchDestinationAddress(driver.IPAddress)
+	ruleConfiguration.MatchDestinationPort(sshDefaultPort)
+	ruleConfiguration.PlaceFirst()
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
+	}
+
+	firewallRuleID, err := driver.auditCreateFirewallRule(client, ruleConfiguration)
+	if err != nil {
+		return err
+	}
+
+	driver.SSHBootstrapFirewallRuleID = firewallRuleID
+
+	log.Debugf("Created SSH bootstrap firewall rule '%s' for server '%s'.", driver.SSHBootstrapFirewallRuleID, driver.ServerID)
+
+	return nil
+}
+
+// Delete the firewall rule that enables inbound SSH connections on the default SSH port.
+func (driver *Driver) deleteSSHBootstrapFirewallRule() error {
+	if !driver.isSSHBootstrapFirewallRuleCreated() {
+		return fmt.Errorf("SSH bootstrap firewall rule has not been created for server '%s'", driver.MachineName)
+	}
+
+	log.Debugf("Deleting SSH bootstrap firewall rule '%s' for server '%s'...",
+		driver.SSHBootstrapFirewallRuleID,
+		driver.MachineName,
+	)
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
+	}
+
+	err = driver.audit("DeleteFirewallRule", auditResources{"firewallRule": driver.SSHBootstrapFirewallRuleID}, func() error {
+		return client.DeleteFirewallRule(driver.SSHBootstrapFirewallRuleID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("SSH bootstrap firewall rule '%s' not found; will treat it as already deleted.", driver.SSHBootstrapFirewallRuleID)
+	} else if err != nil {
+		return err
+	}
+
+	log.Debugf("Deleted SSH bootstrap firewall rule '%s'.", driver.SSHBootstrapFirewallRuleID)
+
+	driver.SSHBootstrapFirewallRuleID = ""
+
+	return nil
+}
+
+// Get the port on which the Docker daemon listens for API connections.
+func (driver *Driver) getDockerPort() int {
+	// Machines created by older versions of the driver always used the default port.
+	if driver.DockerPort == 0 {
+		return DefaultDockerSSLPort
+	}
+
+	return driver.DockerPort
+}
+
 // Has a firewall rule been created to allow inbound Docker for the server?
 func (driver *Driver) isDockerFirewallRuleCreated() bool {
 	return driver.DockerFirewallRuleID != ""
@@ -707,7 +1106,7 @@ func (driver *Driver) createDockerFirewallRule() error {
 
 	log.Debugf("Creating Docker firewall rule for server '%s' (allow inbound traffic on port %d from '%s' to '%s')...",
 		driver.MachineName,
-		DefaultDockerSSLPort,
+		driver.getDockerPort(),
 		driver.ClientPublicIPAddress,
 		driver.IPAddress,
 	)
@@ -722,7 +1121,7 @@ func (driver *Driver) createDockerFirewallRule() error {
 	ruleConfiguration.TCP()
 	ruleConfiguration.MatchSourceAddress(driver.ClientPublicIPAddress)
 	ruleConfiguration.MatchDestinationAddress(driver.IPAddress)
-	ruleConfiguration.MatchDestinationPort(DefaultDockerSSLPort)
+	ruleConfiguration.MatchDestinationPort(driver.getDockerPort())
 	ruleConfiguration.PlaceFirst()
 
 	client, err := driver.getCloudControlClient()
@@ -730,12 +1129,13 @@ func (driver *Driver) createDockerFirewallRule() error {
 		return err
 	}
 
-	firewallRuleID, err := client.CreateFirewallRule(ruleConfiguration)
+	firewallRuleID, err := driver.auditCreateFirewallRule(client, ruleConfiguration)
 	if err != nil {
 		return err
 	}
 
 	driver.DockerFirewallRuleID = firewallRuleID
+	driver.DockerFirewallRulePort = driver.getDockerPort()
 
 	log.Debugf("Created Docker firewall rule '%s' for server '%s'.", driver.DockerFirewallRuleID, driver.ServerID)
 
@@ -744,10 +1144,6 @@ func (driver *Driver) createDockerFirewallRule() error {
 
 // Delete the firewall rule that enables inbound Docker connections to the target server from the client machine's (external) IP address.
 func (driver *Driver) deleteDockerFirewallRule() error {
-	if !driver.isServerCreated() {
-		return fmt.Errorf("Server '%s' has not been created", driver.MachineName)
-	}
-
 	if !driver.isDockerFirewallRuleCreated() {
 		return fmt.Errorf("Docker firewall rule has not been created for server '%s'", driver.MachineName)
 	}
@@ -762,14 +1158,19 @@ func (driver *Driver) deleteDockerFirewallRule() error {
 		return err
 	}
 
-	err = client.DeleteFirewallRule(driver.DockerFirewallRuleID)
-	if err != nil {
+	err = driver.audit("DeleteFirewallRule", auditResources{"firewallRule": driver.DockerFirewallRuleID}, func() error {
+		return client.DeleteFirewallRule(driver.DockerFirewallRuleID)
+	})
+	if compute.IsResourceNotFoundError(err) {
+		log.Debugf("Docker firewall rule '%s' not found; will treat it as already deleted.", driver.DockerFirewallRuleID)
+	} else if err != nil {
 		return err
 	}
 
 	log.Debugf("Deleted Docker firewall rule '%s'.", driver.DockerFirewallRuleID)
 
 	driver.DockerFirewallRuleID = ""
+	driver.DockerFirewallRulePort = 0
 
 	return nil
 }
@@ -783,3 +1184,16 @@ func (driver *Driver) buildFirewallRuleName(suffix string) string {
 		firewallRuleNameSanitizer.Replace(driver.MachineName) + "." + suffix,
 	)
 }
+
+// Create a firewall rule (and record it in the audit log).
+func (driver *Driver) auditCreateFirewallRule(client *compute.Client, ruleConfiguration compute.FirewallRuleConfiguration) (firewallRuleID string, err error) {
+	resources := auditResources{"networkDomain": ruleConfiguration.NetworkDomainID}
+	err = driver.audit("CreateFirewallRule", resources, func() (createErr error) {
+		firewallRuleID, createErr = client.CreateFirewallRule(ruleConfiguration)
+		resources["firewallRule"] = firewallRuleID
+
+		return
+	})
+
+	return
+}
@@ -4,7 +4,7 @@ package main
  * Detect the client machine's external IPv4 address
  * -------------------------------------------------
  *
- * Uses https://v4.ifconfig.co/json
+ * Uses https://v4.ifconfig.co/json (with the same proxy and TLS settings as the CloudControl API client).
  */
 
 import (
@@ -16,21 +16,38 @@ import (
 	"github.com/docker/machine/libmachine/log"
 )
 
+// The URL of the service used to detect the client machine's public IPv4 address.
+const clientPublicIPServiceURL = "https://v4.ifconfig.co/json"
+
 // A subset of the IP address information returned by ifconfig.co.
 type ipInfo struct {
 	IPAddress string `json:"ip"`
 }
 
 // Retrieve the client machine's public IPv4 address.
-func getClientPublicIPv4Address() (string, error) {
+func (driver *Driver) getClientPublicIPv4Address() (string, error) {
 	log.Infof("Auto-detecting client's public (external) IP address...")
 
-	response, err := http.Get("https://v4.ifconfig.co/json")
+	httpClient, err := driver.getHTTPClient()
+	if err != nil {
+		return "", err
+	}
+
+	return getClientPublicIPv4Address(httpClient, clientPublicIPServiceURL)
+}
+
+// Retrieve the client machine's public IPv4 address from the specified service.
+func getClientPublicIPv4Address(httpClient *http.Client, serviceURL string) (string, error) {
+	response, err := httpClient.Get(serviceURL)
 	if err != nil {
 		return "", fmt.Errorf("Unable to connect to ifconfig.co to determine your IP address: %s", err.Error())
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to determine your IP address (ifconfig.co returned '%s')", response.Status)
+	}
+
 	responseBody, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return "", err
@@ -0,0 +1,580 @@
+package main
+
+/*
+ * Cluster provisioning
+ * --------------------
+ *
+ * "docker-machine-driver-ddcloud cluster create" creates several machines at once (using the same driver options as "docker-machine create").
+ *
+ * Shared resources (network domain, VLAN, image, and client public IP address) are resolved once, enough public IP addresses are allocated up-front,
+ * and machines are then created concurrently (via libmachine, so each one gets a standard Docker Machine store entry).
+ *
+ * Each public IP block allocated up-front is recorded in the configuration of every node that uses one of its addresses (so it is released when the last of them is removed);
+ * blocks that are not used by any node are reported (they must be released manually).
+ * Operations performed on behalf of all nodes are recorded in the cluster audit log ("ddcloud-cluster/audit.log" in the Docker Machine store folder).
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/cert"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/persist"
+)
+
+// Cluster defaults.
+const (
+	// The default template for cluster node names ("%d" is replaced with the node number).
+	defaultClusterNameTemplate = "node-%d"
+
+	// The default number of nodes created concurrently.
+	defaultClusterParallelism = 4
+
+	// The folder (in the Docker Machine store folder) containing the cluster audit log.
+	clusterAuditFolderName = "ddcloud-cluster"
+)
+
+// Options for "cluster create".
+type clusterOptions struct {
+	// The number of nodes to create.
+	Count int
+
+	// The template for node names ("%d" is replaced with the node number).
+	NameTemplate string
+
+	// The maximum number of nodes to create concurrently.
+	Parallelism int
+
+	// The Docker Machine store path.
+	StoragePath string
+}
+
+// The outcome of creating a cluster node.
+type clusterNodeResult struct {
+	// The node's machine name.
+	Name string
+
+	// The node's IP address (if it was created).
+	IPAddress string
+
+	// The error (if any) encountered while creating the node.
+	Err error
+}
+
+// Driver options (for SetConfigFromFlags) parsed from the command line.
+type commandLineDriverOptions struct {
+	flags *flag.FlagSet
+}
+
+func (options *commandLineDriverOptions) value(key string) interface{} {
+	cliFlag := options.flags.Lookup(key)
+	if cliFlag == nil {
+		return nil
+	}
+
+	return cliFlag.Value.(flag.Getter).Get()
+}
+
+// String retrieves the value of a string option.
+func (options *commandLineDriverOptions) String(key string) string {
+	value, _ := options.value(key).(string)
+
+	return value
+}
+
+// StringSlice retrieves the value of a string-slice option.
+func (options *commandLineDriverOptions) StringSlice(key string) []string {
+	value, _ := options.value(key).([]string)
+
+	return value
+}
+
+// Int retrieves the value of an integer option.
+func (options *commandLineDriverOptions) Int(key string) int {
+	value, _ := options.value(key).(int)
+
+	return value
+}
+
+// Bool retrieves the value of a boolean option.
+func (options *commandLineDriverOptions) Bool(key string) bool {
+	value, _ := options.value(key).(bool)
+
+	return value
+}
+
+// A command-line flag that can be specified multiple times.
+type stringSliceValue []string
+
+func (value *stringSliceValue) String() string {
+	return strings.Join(*value, ",")
+}
+
+func (value *stringSliceValue) Set(item string) error {
+	*value = append(*value, item)
+
+	return nil
+}
+
+func (value *stringSliceValue) Get() interface{} {
+	return []string(*value)
+}
+
+// Register the driver's create flags (with defaults from their environment variables, if set) in a command-line flag set.
+func registerDriverFlags(flags *flag.FlagSet, driverFlags []mcnflag.Flag) {
+	for _, driverFlag := range driverFlags {
+		switch typedFlag := driverFlag.(type) {
+		case mcnflag.StringFlag:
+			value := typedFlag.Value
+			if typedFlag.EnvVar != "" && os.Getenv(typedFlag.EnvVar) != "" {
+				value = os.Getenv(typedFlag.EnvVar)
+			}
+			flags.String(typedFlag.Name, value, typedFlag.Usage)
+
+		case mcnflag.IntFlag:
+			value := typedFlag.Value
+			if typedFlag.EnvVar != "" && os.Getenv(typedFlag.EnvVar) != "" {
+				envValue, err := strconv.Atoi(os.Getenv(typedFlag.EnvVar))
+				if err == nil {
+					value = envValue
+				}
+			}
+			flags.Int(typedFlag.Name, value, typedFlag.Usage)
+
+		case mcnflag.BoolFlag:
+			value := false
+			if typedFlag.EnvVar != "" && os.Getenv(typedFlag.EnvVar) != "" {
+				value, _ = strconv.ParseBool(os.Getenv(typedFlag.EnvVar))
+			}
+			flags.Bool(typedFlag.Name, value, typedFlag.Usage)
+
+		case mcnflag.StringSliceFlag:
+			value := stringSliceValue(typedFlag.Value)
+			flags.Var(&value, typedFlag.Name, typedFlag.Usage)
+		}
+	}
+}
+
+// Get the default Docker Machine store path.
+func defaultMachineStoragePath() string {
+	storagePath := os.Getenv("MACHINE_STORAGE_PATH")
+	if storagePath != "" {
+		return storagePath
+	}
+
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(homeDirectory, ".docker", "machine")
+}
+
+// Run "cluster create" with the specified command-line arguments.
+func runClusterCreate(args []string) error {
+	template := &Driver{BaseDriver: &drivers.BaseDriver{
+		SSHUser: "root",
+		SSHPort: 22,
+	}}
+
+	options := clusterOptions{}
+	flags := flag.NewFlagSet("cluster create", flag.ContinueOnError)
+	flags.IntVar(&options.Count, "count", 0, "The number of nodes to create")
+	flags.StringVar(&options.NameTemplate, "name-template", defaultClusterNameTemplate, "The template for node names (\"%d\" is replaced with the node number)")
+	flags.IntVar(&options.Parallelism, "parallelism", defaultClusterParallelism, "The maximum number of nodes to create concurrently")
+	flags.StringVar(&options.StoragePath, "storage-path", defaultMachineStoragePath(), "The Docker Machine store path")
+	registerDriverFlags(flags, template.GetCreateFlags())
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("Unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	nodeNames, err := options.getNodeNames()
+	if err != nil {
+		return err
+	}
+
+	template.StorePath = options.StoragePath
+	template.auditLogFile, err = getClusterAuditLogFile(options.StoragePath)
+	if err != nil {
+		return err
+	}
+	err = template.SetConfigFromFlags(&commandLineDriverOptions{flags})
+	if err != nil {
+		return err
+	}
+	if template.isAdoptingExistingServer() {
+		return errors.New("--ddcloud-existing-server cannot be used with \"cluster create\"")
+	}
+	if template.DryRun {
+		return errors.New("--ddcloud-dry-run cannot be used with \"cluster create\"")
+	}
+
+	allocatedBlocks, err := template.prepareCluster(len(nodeNames))
+	if err != nil {
+		return err
+	}
+
+	results := template.createClusterNodes(nodeNames, options)
+	failureCount := printClusterNodeResults(results, os.Stdout)
+
+	unusedBlocks := recordClusterPublicIPBlocks(allocatedBlocks, results, options.StoragePath)
+	printUnusedPublicIPBlocks(unusedBlocks, os.Stdout)
+
+	if failureCount > 0 {
+		return fmt.Errorf("Failed to create %d of %d nodes (use \"docker-machine rm\" to clean up failed nodes)", failureCount, len(results))
+	}
+
+	return nil
+}
+
+// Get the path of the cluster audit log (creating its folder, if required).
+func getClusterAuditLogFile(storagePath string) (string, error) {
+	auditFolder := filepath.Join(storagePath, clusterAuditFolderName)
+	err := os.MkdirAll(auditFolder, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(auditFolder, auditLogFileName), nil
+}
+
+// Get the names of the cluster nodes to create.
+func (options clusterOptions) getNodeNames() ([]string, error) {
+	if options.Count < 1 {
+		return nil, errors.New("Node count (--count) must be at least 1")
+	}
+	if options.Parallelism < 1 {
+		return nil, errors.New("Parallelism (--parallelism) must be at least 1")
+	}
+	if options.StoragePath == "" {
+		return nil, errors.New("Docker Machine store path (--storage-path) has not been specified")
+	}
+	if strings.Count(options.NameTemplate, "%") != 1 || !strings.Contains(options.NameTemplate, "d") {
+		return nil, fmt.Errorf("Invalid node name template '%s' (must contain a single '%%d' for the node number)", options.NameTemplate)
+	}
+
+	nodeNames := make([]string, options.Count)
+	for index := range nodeNames {
+		nodeName := fmt.Sprintf(options.NameTemplate, index+1)
+		if strings.Contains(nodeName, "%!") || !host.ValidateHostName(nodeName) {
+			return nil, fmt.Errorf("Invalid node name template '%s' (produces invalid machine name '%s')", options.NameTemplate, nodeName)
+		}
+		nodeNames[index] = nodeName
+	}
+
+	return nodeNames, nil
+}
+
+// Resolve resources shared by all cluster nodes, and allocate enough public IP addresses for them.
+//
+// Returns the public IP blocks (if any) that were allocated.
+func (driver *Driver) prepareCluster(nodeCount int) ([]compute.PublicIPBlock, error) {
+	log.Infof("Resolving shared resources for %d nodes...", nodeCount)
+
+	err := driver.PreCreateCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	if driver.UsePrivateIP {
+		return nil, nil
+	}
+
+	if (driver.CreateSSHFirewallRule || driver.CreateDockerFirewallRule) && driver.ClientPublicIPAddress == "" {
+		driver.ClientPublicIPAddress, err = driver.getClientPublicIPv4Address()
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Client public IP address is '%s'.", driver.ClientPublicIPAddress)
+	}
+
+	return driver.reservePublicIPAddresses(nodeCount)
+}
+
+// Ensure that the target network domain has at least the specified number of public IP addresses available.
+//
+// Returns the public IP blocks (if any) that were allocated.
+func (driver *Driver) reservePublicIPAddresses(count int) ([]compute.PublicIPBlock, error) {
+	lock, err := driver.lockNetworkDomain()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var allocatedBlocks []compute.PublicIPBlock
+	for {
+		var availableIPs map[string]string
+		availableIPs, err = client.GetAvailablePublicIPAddresses(driver.NetworkDomainID)
+		if err != nil {
+			return allocatedBlocks, err
+		}
+		if len(availableIPs) >= count {
+			log.Infof("Network domain '%s' has %d public IP addresses available.", driver.NetworkDomainName, len(availableIPs))
+
+			return allocatedBlocks, nil
+		}
+
+		log.Infof("Network domain '%s' has %d of %d required public IP addresses available; allocating a new block of public IPs...",
+			driver.NetworkDomainName,
+			len(availableIPs),
+			count,
+		)
+
+		var blockID string
+		resources := auditResources{"networkDomain": driver.NetworkDomainID}
+		err = driver.audit("AddPublicIPBlock", resources, func() (addErr error) {
+			blockID, addErr = client.AddPublicIPBlock(driver.NetworkDomainID)
+			resources["publicIPBlock"] = blockID
+
+			return
+		})
+		if err != nil {
+			return allocatedBlocks, err
+		}
+
+		var block *compute.PublicIPBlock
+		block, err = client.GetPublicIPBlock(blockID)
+		if err != nil {
+			return allocatedBlocks, err
+		}
+		if block == nil {
+			return allocatedBlocks, fmt.Errorf("Failed to retrieve newly-allocated public IP block '%s'", blockID)
+		}
+		allocatedBlocks = append(allocatedBlocks, *block)
+
+		log.Infof("Allocated new public IP block '%s' (%s/%d).", block.ID, block.BaseIP, block.Size)
+	}
+}
+
+// Create the cluster nodes (concurrently), using the driver as a template for each node's configuration.
+func (driver *Driver) createClusterNodes(nodeNames []string, options clusterOptions) []clusterNodeResult {
+	api := libmachine.NewClient(options.StoragePath,
+		filepath.Join(options.StoragePath, "certs"),
+	)
+	defer api.Close()
+
+	results := make([]clusterNodeResult, len(nodeNames))
+	hosts := make([]*host.Host, len(nodeNames))
+	for index, nodeName := range nodeNames {
+		results[index].Name = nodeName
+		hosts[index], results[index].Err = driver.newClusterNodeHost(api, nodeName)
+	}
+
+	// Certificates are shared by all machines, so they must be generated before any nodes are created.
+	for _, nodeHost := range hosts {
+		if nodeHost == nil {
+			continue
+		}
+
+		err := cert.BootstrapCertificates(nodeHost.AuthOptions())
+		if err != nil {
+			for index := range results {
+				if results[index].Err == nil {
+					results[index].Err = fmt.Errorf("Unable to generate certificates: %s", err.Error())
+				}
+			}
+
+			return results
+		}
+
+		break
+	}
+
+	var waitGroup sync.WaitGroup
+	slots := make(chan struct{}, options.Parallelism)
+	for index := range hosts {
+		if results[index].Err != nil {
+			continue
+		}
+
+		waitGroup.Add(1)
+		go func(result *clusterNodeResult, nodeHost *host.Host) {
+			defer waitGroup.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			log.Infof("Creating node '%s'...", result.Name)
+
+			result.Err = api.Create(nodeHost)
+			if result.Err != nil {
+				log.Errorf("Failed to create node '%s': %s", result.Name, result.Err.Error())
+
+				return
+			}
+
+			result.IPAddress, _ = nodeHost.Driver.GetIP()
+
+			log.Infof("Created node '%s'.", result.Name)
+		}(&results[index], hosts[index])
+	}
+	waitGroup.Wait()
+
+	return results
+}
+
+// Create a Docker Machine host for a cluster node (using the driver as a template for the node's configuration).
+func (driver *Driver) newClusterNodeHost(api *libmachine.Client, nodeName string) (*host.Host, error) {
+	exists, err := api.Exists(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("Machine '%s' already exists", nodeName)
+	}
+
+	nodeBaseDriver := *driver.BaseDriver
+	nodeBaseDriver.MachineName = nodeName
+
+	nodeDriver := *driver
+	nodeDriver.BaseDriver = &nodeBaseDriver
+
+	rawDriver, err := json.Marshal(&nodeDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.NewHost(driver.DriverName(), rawDriver)
+}
+
+// Record each public IP block allocated for the cluster in the configuration of every node that uses one of its addresses.
+//
+// The block is then released when the last of those nodes is removed. Returns the blocks (if any) that are not used by any node.
+func recordClusterPublicIPBlocks(blocks []compute.PublicIPBlock, results []clusterNodeResult, storagePath string) []compute.PublicIPBlock {
+	store := persist.NewFilestore(storagePath, "", "")
+
+	var unusedBlocks []compute.PublicIPBlock
+	for index := range blocks {
+		block := &blocks[index]
+
+		used := false
+		for _, result := range results {
+			if result.Err != nil {
+				continue
+			}
+
+			inBlock, err := isAddressInPublicIPBlock(result.IPAddress, block)
+			if err != nil {
+				log.Warnf("Unable to determine whether node '%s' uses public IP block '%s': %s", result.Name, block.ID, err.Error())
+
+				continue
+			}
+			if !inBlock {
+				continue
+			}
+			used = true
+
+			err = recordClusterNodePublicIPBlock(store, result.Name, block.ID)
+			if err != nil {
+				log.Warnf("Unable to record public IP block '%s' for node '%s' (it will not be released when the node is removed): %s", block.ID, result.Name, err.Error())
+			}
+		}
+
+		if !used {
+			unusedBlocks = append(unusedBlocks, *block)
+		}
+	}
+
+	return unusedBlocks
+}
+
+// Record a public IP block in a cluster node's configuration (in the Docker Machine store).
+func recordClusterNodePublicIPBlock(store *persist.Filestore, nodeName string, blockID string) error {
+	nodeHost, err := store.Load(nodeName)
+	if err != nil {
+		return err
+	}
+	rawDriver, ok := nodeHost.Driver.(*host.RawDataDriver)
+	if !ok {
+		return fmt.Errorf("Unexpected driver type %T", nodeHost.Driver)
+	}
+
+	// Only the block Id is changed (other configuration is preserved as-is).
+	var nodeConfig map[string]json.RawMessage
+	err = json.Unmarshal(rawDriver.Data, &nodeConfig)
+	if err != nil {
+		return err
+	}
+
+	var existingBlockID string
+	if nodeConfig["PublicIPBlockID"] != nil {
+		err = json.Unmarshal(nodeConfig["PublicIPBlockID"], &existingBlockID)
+		if err != nil {
+			return err
+		}
+	}
+	if existingBlockID != "" && existingBlockID != blockID {
+		return fmt.Errorf("Node already records public IP block '%s'", existingBlockID)
+	}
+
+	nodeConfig["PublicIPBlockID"], err = json.Marshal(blockID)
+	if err != nil {
+		return err
+	}
+	rawDriver.Data, err = json.Marshal(nodeConfig)
+	if err != nil {
+		return err
+	}
+
+	return store.Save(nodeHost)
+}
+
+// Print the public IP blocks (if any) that were allocated for the cluster but are not used by any node.
+func printUnusedPublicIPBlocks(blocks []compute.PublicIPBlock, writer io.Writer) {
+	for _, block := range blocks {
+		fmt.Fprintf(writer, "Public IP block '%s' (%s/%d) was allocated for the cluster but is not used by any node; release it using the CloudControl UI or API if it is no longer required.\n",
+			block.ID,
+			block.BaseIP,
+			block.Size,
+		)
+	}
+}
+
+// Print the outcome of creating each cluster node, returning the number of nodes that could not be created.
+func printClusterNodeResults(results []clusterNodeResult, writer io.Writer) int {
+	failureCount := 0
+
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "NODE\tSTATUS\tIP ADDRESS\tERROR")
+	for _, result := range results {
+		status := "created"
+		errorMessage := ""
+		if result.Err != nil {
+			status = "failed"
+			errorMessage = result.Err.Error()
+
+			failureCount++
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", result.Name, status, result.IPAddress, errorMessage)
+	}
+	table.Flush()
+
+	return failureCount
+}
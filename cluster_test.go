@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/persist"
+	"github.com/docker/machine/libmachine/version"
+)
+
+func TestReservePublicIPAddressesRecordsClusterAuditLog(t *testing.T) {
+	fake := newFakeCloudControl(t)
+
+	// The cluster template has no machine name (so it has no audit log of its own).
+	template := fake.newDriver(t, "", "")
+	template.NetworkDomainID = "network-domain-1"
+
+	var err error
+	template.auditLogFile, err = getClusterAuditLogFile(template.StorePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocatedBlocks, err := template.reservePublicIPAddresses(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocatedBlocks) != 2 || len(fake.PublicIPBlocks) != 2 {
+		t.Fatalf("Allocated %d public IP blocks (expected 2 blocks of %d addresses for 3 nodes)", len(allocatedBlocks), fakePublicIPBlockSize)
+	}
+	for index, block := range allocatedBlocks {
+		if block.ID != fake.PublicIPBlocks[index].ID || block.BaseIP != fake.PublicIPBlocks[index].BaseIP {
+			t.Errorf("Allocated block %d is '%s' (%s); expected '%s' (%s)", index, block.ID, block.BaseIP, fake.PublicIPBlocks[index].ID, fake.PublicIPBlocks[index].BaseIP)
+		}
+	}
+
+	records, err := readAuditLog(filepath.Join(template.StorePath, clusterAuditFolderName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Cluster audit log has %d records (expected 2)", len(records))
+	}
+	for index, record := range records {
+		if record.Operation != "AddPublicIPBlock" || record.Resources["publicIPBlock"] != allocatedBlocks[index].ID {
+			t.Errorf("Unexpected audit record %d: %s %v", index, record.Operation, record.Resources)
+		}
+	}
+
+	// No more blocks are required.
+	allocatedBlocks, err = template.reservePublicIPAddresses(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allocatedBlocks) != 0 {
+		t.Fatalf("Allocated %d public IP blocks (expected none)", len(allocatedBlocks))
+	}
+}
+
+// Save a cluster node's configuration in the Docker Machine store.
+func saveTestClusterNode(t *testing.T, store *persist.Filestore, nodeName string, publicIPBlockID string) {
+	t.Helper()
+
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: nodeName,
+			StorePath:   store.Path,
+			SSHPort:     22,
+		},
+		NetworkDomainID: "network-domain-1",
+		PublicIPBlockID: publicIPBlockID,
+	}
+	err := store.Save(&host.Host{
+		ConfigVersion: version.ConfigVersion,
+		Driver:        driver,
+		DriverName:    driver.DriverName(),
+		HostOptions: &host.Options{
+			AuthOptions: &auth.Options{StorePath: driver.ResolveStorePath(".")},
+		},
+		Name: nodeName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Load a cluster node's driver configuration from the Docker Machine store.
+func loadTestClusterNode(t *testing.T, store *persist.Filestore, nodeName string) *Driver {
+	t.Helper()
+
+	configData, err := ioutil.ReadFile(filepath.Join(store.GetMachinesDir(), nodeName, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config struct {
+		Driver *Driver
+	}
+	err = json.Unmarshal(configData, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config.Driver
+}
+
+func TestRecordClusterPublicIPBlocks(t *testing.T) {
+	store := persist.NewFilestore(t.TempDir(), "", "")
+	saveTestClusterNode(t, store, "node-1", "")
+	saveTestClusterNode(t, store, "node-2", "")
+	saveTestClusterNode(t, store, "node-3", "")
+	saveTestClusterNode(t, store, "node-4", "public-ip-block-9") // Allocated its own block.
+
+	blocks := []compute.PublicIPBlock{
+		{ID: "public-ip-block-1", BaseIP: "203.0.113.1", Size: 2},
+		{ID: "public-ip-block-2", BaseIP: "203.0.113.3", Size: 2},
+		{ID: "public-ip-block-3", BaseIP: "203.0.113.5", Size: 2},
+	}
+	results := []clusterNodeResult{
+		{Name: "node-1", IPAddress: "203.0.113.1"},
+		{Name: "node-2", IPAddress: "203.0.113.2"},
+		{Name: "node-3", Err: errors.New("Failed to create node")},
+		{Name: "node-4", IPAddress: "203.0.113.3"},
+	}
+
+	unusedBlocks := recordClusterPublicIPBlocks(blocks, results, store.Path)
+
+	for _, nodeName := range []string{"node-1", "node-2"} {
+		node := loadTestClusterNode(t, store, nodeName)
+		if node.PublicIPBlockID != "public-ip-block-1" {
+			t.Errorf("Node '%s' records public IP block '%s' (expected 'public-ip-block-1')", nodeName, node.PublicIPBlockID)
+		}
+		if node.NetworkDomainID != "network-domain-1" || node.SSHPort != 22 {
+			t.Errorf("Node '%s' configuration was not preserved (network domain '%s', SSH port %d)", nodeName, node.NetworkDomainID, node.SSHPort)
+		}
+	}
+	if node := loadTestClusterNode(t, store, "node-3"); node.PublicIPBlockID != "" {
+		t.Errorf("Failed node 'node-3' records public IP block '%s'", node.PublicIPBlockID)
+	}
+	if node := loadTestClusterNode(t, store, "node-4"); node.PublicIPBlockID != "public-ip-block-9" {
+		t.Errorf("Node 'node-4' records public IP block '%s' (expected its own block, 'public-ip-block-9')", node.PublicIPBlockID)
+	}
+
+	if len(unusedBlocks) != 1 || unusedBlocks[0].ID != "public-ip-block-3" {
+		t.Fatalf("Unused public IP blocks are %v (expected only 'public-ip-block-3')", unusedBlocks)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+/*
+ * CloudControl credential profiles
+ * --------------------------------
+ *
+ * Credentials can be read from a profiles file (by default, ~/.ddcloud/credentials) so they don't have to be passed on the command line
+ * (or persisted in the machine's configuration; only the profile name is persisted, and the credentials are read each time they are needed).
+ *
+ * The file is in INI format, with one section per profile:
+ *
+ *   [default]
+ *   user     = my_user
+ *   password = my_password
+ *   region   = AU
+ *
+ *   [lab]
+ *   user     = my_lab_user
+ *   password = my_lab_password
+ *   endpoint = https://api-lab.example.com
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The environment variable used to override the location of the credentials file.
+const credentialsFileEnvVar = "MCP_CREDENTIALS_FILE"
+
+// CloudControl credentials (and connection details) from a profile.
+type credentialProfile struct {
+	// The CloudControl user name.
+	User string
+
+	// The CloudControl password.
+	Password string
+
+	// The CloudControl region name (optional).
+	Region string
+
+	// A custom CloudControl API end-point URI (optional).
+	EndPointURI string
+}
+
+// Get the default location of the credentials file.
+func defaultCredentialsFile() (string, error) {
+	credentialsFile := os.Getenv(credentialsFileEnvVar)
+	if credentialsFile != "" {
+		return credentialsFile, nil
+	}
+
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homeDirectory, ".ddcloud", "credentials"), nil
+}
+
+// Read the named profile from a credentials file.
+func readCredentialProfile(credentialsFile string, profileName string) (*credentialProfile, error) {
+	file, err := os.Open(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open credentials file: %s", err.Error())
+	}
+	defer file.Close()
+
+	var (
+		profile        *credentialProfile
+		currentSection string
+		lineNumber     int
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			currentSection = strings.TrimSpace(line[1 : len(line)-1])
+			if currentSection == profileName {
+				profile = &credentialProfile{}
+			}
+
+			continue
+		}
+
+		if currentSection != profileName {
+			continue
+		}
+
+		separatorIndex := strings.IndexAny(line, "=:")
+		if separatorIndex == -1 {
+			return nil, fmt.Errorf("Invalid entry on line %d of credentials file '%s' (expected 'key = value')", lineNumber, credentialsFile)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:separatorIndex]))
+		value := strings.TrimSpace(line[separatorIndex+1:])
+
+		switch key {
+		case "user", "username":
+			profile.User = value
+		case "password":
+			profile.Password = value
+		case "region":
+			profile.Region = value
+		case "endpoint":
+			profile.EndPointURI = value
+		default:
+			return nil, fmt.Errorf("Unrecognised key '%s' on line %d of credentials file '%s'", key, lineNumber, credentialsFile)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return nil, fmt.Errorf("Profile '%s' not found in credentials file '%s'", profileName, credentialsFile)
+	}
+
+	return profile, nil
+}
+
+// Get the CloudControl credentials (and connection details) for the driver, either from its configuration or from the configured profile.
+func (driver *Driver) getCloudControlCredentials() (*credentialProfile, error) {
+	if driver.CloudControlProfile == "" {
+		password, err := driver.decryptSecret(driver.CloudControlPassword)
+		if err != nil {
+			return nil, err
+		}
+
+		return driver.inferCloudControlRegion(&credentialProfile{
+			User:        driver.CloudControlUser,
+			Password:    password,
+			Region:      driver.CloudControlRegion,
+			EndPointURI: driver.CloudControlEndPointURI,
+		}), nil
+	}
+
+	credentialsFile := driver.CloudControlCredentialsFile
+	if credentialsFile == "" {
+		var err error
+		credentialsFile, err = defaultCredentialsFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	profile, err := readCredentialProfile(credentialsFile, driver.CloudControlProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Region / end-point from the driver's configuration take precedence over those from the profile.
+	if driver.CloudControlRegion != "" || driver.CloudControlEndPointURI != "" {
+		profile.Region = driver.CloudControlRegion
+		profile.EndPointURI = driver.CloudControlEndPointURI
+	}
+
+	return driver.inferCloudControlRegion(profile), nil
+}
+
+// If neither region nor end-point have been configured, infer the region from the target data centre Id.
+func (driver *Driver) inferCloudControlRegion(credentials *credentialProfile) *credentialProfile {
+	if credentials.Region == "" && credentials.EndPointURI == "" {
+		credentials.Region = inferRegionFromDataCenterID(driver.DataCenterID)
+	}
+
+	return credentials
+}
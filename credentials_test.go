@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+const testCredentialsFileContent = `
+# Comments and blank lines are ignored.
+; So are INI-style comments.
+
+[default]
+user     = default_user
+password = default_password
+region   = AU
+
+  [ lab ]
+	username:lab_user
+  password =  lab password with spaces  
+endpoint = https://api-lab.example.com
+`
+
+// Write a credentials file for testing.
+func writeTestCredentialsFile(t *testing.T, content string) string {
+	t.Helper()
+
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	err := ioutil.WriteFile(credentialsFile, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentialsFile
+}
+
+func TestReadCredentialProfile(t *testing.T) {
+	credentialsFile := writeTestCredentialsFile(t, testCredentialsFileContent)
+
+	testCases := []struct {
+		ProfileName string
+		Expected    credentialProfile
+	}{
+		{"default", credentialProfile{User: "default_user", Password: "default_password", Region: "AU"}},
+		{"lab", credentialProfile{User: "lab_user", Password: "lab password with spaces", EndPointURI: "https://api-lab.example.com"}},
+	}
+
+	for _, testCase := range testCases {
+		profile, err := readCredentialProfile(credentialsFile, testCase.ProfileName)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.ProfileName, err)
+		}
+		if *profile != testCase.Expected {
+			t.Errorf("%s: read profile %+v (expected %+v)", testCase.ProfileName, *profile, testCase.Expected)
+		}
+	}
+}
+
+func TestReadCredentialProfileErrors(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Content       string
+		ProfileName   string
+		ExpectedError string
+	}{
+		{"Missing profile", testCredentialsFileContent, "production", "Profile 'production' not found"},
+		{"Invalid entry", "[default]\nuser\n", "default", "Invalid entry on line 2"},
+		{"Unrecognised key", "[default]\n# Comment\nregoin = AU\n", "default", "Unrecognised key 'regoin' on line 3"},
+	}
+
+	for _, testCase := range testCases {
+		credentialsFile := writeTestCredentialsFile(t, testCase.Content)
+
+		_, err := readCredentialProfile(credentialsFile, testCase.ProfileName)
+		if err == nil || !strings.Contains(err.Error(), testCase.ExpectedError) {
+			t.Errorf("%s: expected error containing '%s' (got %v)", testCase.Name, testCase.ExpectedError, err)
+		}
+	}
+
+	_, err := readCredentialProfile(filepath.Join(t.TempDir(), "missing"), "default")
+	if err == nil || !strings.Contains(err.Error(), "Unable to open credentials file") {
+		t.Errorf("Missing file: expected error opening credentials file (got %v)", err)
+	}
+}
+
+func TestGetCloudControlCredentialsFromProfile(t *testing.T) {
+	credentialsFile := writeTestCredentialsFile(t, testCredentialsFileContent)
+
+	testCases := []struct {
+		Name        string
+		ProfileName string
+		Region      string
+		EndPointURI string
+		Expected    credentialProfile
+	}{
+		{"Profile region", "default", "", "", credentialProfile{User: "default_user", Password: "default_password", Region: "AU"}},
+		{"Profile end-point", "lab", "", "", credentialProfile{User: "lab_user", Password: "lab password with spaces", EndPointURI: "https://api-lab.example.com"}},
+		{"Region overrides profile", "default", "EU", "", credentialProfile{User: "default_user", Password: "default_password", Region: "EU"}},
+		{"Region overrides profile end-point", "lab", "EU", "", credentialProfile{User: "lab_user", Password: "lab password with spaces", Region: "EU"}},
+		{"End-point overrides profile region", "default", "", "https://api.example.com", credentialProfile{User: "default_user", Password: "default_password", EndPointURI: "https://api.example.com"}},
+	}
+
+	for _, testCase := range testCases {
+		driver := &Driver{
+			BaseDriver:                  &drivers.BaseDriver{MachineName: "test-machine"},
+			CloudControlProfile:         testCase.ProfileName,
+			CloudControlCredentialsFile: credentialsFile,
+			CloudControlRegion:          testCase.Region,
+			CloudControlEndPointURI:     testCase.EndPointURI,
+		}
+
+		credentials, err := driver.getCloudControlCredentials()
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.Name, err)
+		}
+		if *credentials != testCase.Expected {
+			t.Errorf("%s: got credentials %+v (expected %+v)", testCase.Name, *credentials, testCase.Expected)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+/*
+ * Data centre and region resolution
+ * ---------------------------------
+ *
+ * CloudControl data centre Ids start with the name of their region (e.g. AU9 is in the AU region), so the region can be inferred from the data centre Id.
+ * If no data centre is specified, it can be inferred from the network domain name (as long as only one network domain in the region has that name).
+ */
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Infer the CloudControl region name from a data centre Id (e.g. "AU9" -> "AU").
+func inferRegionFromDataCenterID(dataCenterID string) string {
+	prefixLength := strings.IndexFunc(dataCenterID, func(character rune) bool {
+		return !unicode.IsLetter(character)
+	})
+	if prefixLength == -1 || prefixLength == 0 {
+		return "" // Not a recognised data centre Id.
+	}
+
+	return strings.ToUpper(dataCenterID[:prefixLength])
+}
+
+// Get a description of the CloudControl region (or custom end-point) used by the driver.
+func (driver *Driver) describeCloudControlRegion() string {
+	credentials, err := driver.getCloudControlCredentials()
+	if err != nil {
+		return "unknown"
+	}
+
+	if credentials.Region != "" {
+		return credentials.Region
+	}
+
+	return credentials.EndPointURI
+}
+
+// List the data centres visible in the CloudControl region.
+func (driver *Driver) listDataCenters() ([]compute.Datacenter, error) {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var dataCenters []compute.Datacenter
+
+	page := compute.DefaultPaging()
+	for {
+		var results *compute.Datacenters
+		results, err = client.ListDatacenters(page)
+		if err != nil {
+			return nil, err
+		}
+		if results.IsEmpty() {
+			break // We're done
+		}
+
+		dataCenters = append(dataCenters, results.Items...)
+
+		page.Next()
+	}
+
+	return dataCenters, nil
+}
+
+// List the network domains (in any data centre visible in the CloudControl region) with the specified name.
+func (driver *Driver) listNetworkDomainsByName(name string) ([]compute.NetworkDomain, error) {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var networkDomains []compute.NetworkDomain
+
+	page := compute.DefaultPaging()
+	for {
+		var results *compute.NetworkDomains
+		results, err = client.ListNetworkDomains(page)
+		if err != nil {
+			return nil, err
+		}
+		if results.IsEmpty() {
+			break // We're done
+		}
+
+		for _, networkDomain := range results.Domains {
+			if networkDomain.Name == name {
+				networkDomains = append(networkDomains, networkDomain)
+			}
+		}
+
+		page.Next()
+	}
+
+	return networkDomains, nil
+}
+
+// Resolve (and validate) the target data centre, inferring it from the network domain name if required.
+func (driver *Driver) resolveDataCenter() error {
+	if driver.DataCenterID == "" {
+		err := driver.inferDataCenterFromNetworkDomain()
+		if err != nil {
+			return err
+		}
+	}
+
+	dataCenters, err := driver.listDataCenters()
+	if err != nil {
+		return err
+	}
+
+	dataCenterIDs := make([]string, len(dataCenters))
+	for index, dataCenter := range dataCenters {
+		if strings.EqualFold(dataCenter.ID, driver.DataCenterID) {
+			driver.DataCenterID = dataCenter.ID // Use canonical casing.
+
+			return nil
+		}
+
+		dataCenterIDs[index] = dataCenter.ID
+	}
+	sort.Strings(dataCenterIDs)
+
+	return fmt.Errorf("Data centre '%s' was not found in region '%s' (valid data centres are: %s)",
+		driver.DataCenterID,
+		driver.describeCloudControlRegion(),
+		strings.Join(dataCenterIDs, ", "),
+	)
+}
+
+// Infer the target data centre from the network domain name (only possible if exactly one network domain in the region has that name).
+func (driver *Driver) inferDataCenterFromNetworkDomain() error {
+	if driver.NetworkDomainName == "" {
+		return errors.New("Network domain name has not been configured")
+	}
+
+	log.Infof("Data centre not specified; looking for network domain '%s' in region '%s'...",
+		driver.NetworkDomainName,
+		driver.describeCloudControlRegion(),
+	)
+
+	networkDomains, err := driver.listNetworkDomainsByName(driver.NetworkDomainName)
+	if err != nil {
+		return err
+	}
+
+	switch len(networkDomains) {
+	case 0:
+		return fmt.Errorf("No network domain named '%s' was found in region '%s' (specify the data centre using --ddcloud-datacenter)",
+			driver.NetworkDomainName,
+			driver.describeCloudControlRegion(),
+		)
+	case 1:
+		driver.DataCenterID = networkDomains[0].DatacenterID
+
+		log.Infof("Network domain '%s' is in data centre '%s'.", driver.NetworkDomainName, driver.DataCenterID)
+
+		return nil
+	default:
+		dataCenterIDs := make([]string, len(networkDomains))
+		for index, networkDomain := range networkDomains {
+			dataCenterIDs[index] = networkDomain.DatacenterID
+		}
+		sort.Strings(dataCenterIDs)
+
+		return fmt.Errorf("More than one network domain named '%s' was found in region '%s' (specify the data centre using --ddcloud-datacenter; valid data centres are: %s)",
+			driver.NetworkDomainName,
+			driver.describeCloudControlRegion(),
+			strings.Join(dataCenterIDs, ", "),
+		)
+	}
+}
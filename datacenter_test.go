@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+)
+
+func TestInferRegionFromDataCenterID(t *testing.T) {
+	testCases := []struct {
+		DataCenterID   string
+		ExpectedRegion string
+	}{
+		{"AU9", "AU"},
+		{"au9", "AU"},
+		{"NA12", "NA"},
+		{"EU6", "EU"},
+		{"AF20", "AF"},
+		{"AU", ""},
+		{"9", ""},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		region := inferRegionFromDataCenterID(testCase.DataCenterID)
+		if region != testCase.ExpectedRegion {
+			t.Errorf("Region inferred from data centre '%s' is '%s' (expected '%s')", testCase.DataCenterID, region, testCase.ExpectedRegion)
+		}
+	}
+}
+
+// Create a fake CloudControl API with several data centres and network domains.
+func newDataCenterTestCloudControl(t *testing.T) *fakeCloudControl {
+	t.Helper()
+
+	fake := newFakeCloudControl(t)
+	fake.Datacenters = []compute.Datacenter{
+		{ID: "AU9"},
+		{ID: "AU10"},
+		{ID: "AU11"},
+	}
+	fake.NetworkDomains = []compute.NetworkDomain{
+		{ID: "network-domain-1", Name: "Production", DatacenterID: "AU10", State: compute.ResourceStatusNormal},
+		{ID: "network-domain-2", Name: "Test", DatacenterID: "AU9", State: compute.ResourceStatusNormal},
+		{ID: "network-domain-3", Name: "Test", DatacenterID: "AU11", State: compute.ResourceStatusNormal},
+	}
+
+	return fake
+}
+
+func TestResolveDataCenter(t *testing.T) {
+	testCases := []struct {
+		Name                 string
+		DataCenterID         string
+		NetworkDomainName    string
+		ExpectedDataCenterID string
+		ExpectedErrors       []string
+	}{
+		{"Exact match", "AU10", "", "AU10", nil},
+		{"Case-insensitive match", "au10", "", "AU10", nil},
+		{"Unknown data centre", "AU2", "", "", []string{"Data centre 'AU2' was not found", "valid data centres are: AU10, AU11, AU9"}},
+		{"Inferred from network domain", "", "Production", "AU10", nil},
+		{"Unknown network domain", "", "Staging", "", []string{"No network domain named 'Staging' was found", "--ddcloud-datacenter"}},
+		{"Ambiguous network domain", "", "Test", "", []string{"More than one network domain named 'Test'", "valid data centres are: AU11, AU9"}},
+		{"No data centre or network domain", "", "", "", []string{"Network domain name has not been configured"}},
+	}
+
+	fake := newDataCenterTestCloudControl(t)
+	for _, testCase := range testCases {
+		driver := fake.newDriver(t, "test-machine", "")
+		driver.DataCenterID = testCase.DataCenterID
+		driver.NetworkDomainName = testCase.NetworkDomainName
+
+		err := driver.resolveDataCenter()
+		if len(testCase.ExpectedErrors) == 0 {
+			if err != nil {
+				t.Errorf("%s: %s", testCase.Name, err)
+			} else if driver.DataCenterID != testCase.ExpectedDataCenterID {
+				t.Errorf("%s: resolved data centre '%s' (expected '%s')", testCase.Name, driver.DataCenterID, testCase.ExpectedDataCenterID)
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: resolved data centre '%s' (expected an error)", testCase.Name, driver.DataCenterID)
+
+			continue
+		}
+		for _, expectedError := range testCase.ExpectedErrors {
+			if !strings.Contains(err.Error(), expectedError) {
+				t.Errorf("%s: error '%s' does not contain '%s'", testCase.Name, err, expectedError)
+			}
+		}
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+/*
+ * Dry-run plans
+ * -------------
+ *
+ * With --ddcloud-dry-run, PreCreateCheck performs full validation, prints a plan of what Create would do, and then fails
+ * (so Docker Machine does not go on to call Create); no CloudControl resources are created or modified.
+ *
+ * Docker Machine reports this as a pre-create check error and exits with status 3 (without saving the machine).
+ * The "plan" command runs the same checks outside Docker Machine, and exits with status 0 once the plan has been printed.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Dry-run plan formats.
+const (
+	// DryRunFormatText prints the dry-run plan as text.
+	DryRunFormatText = "text"
+
+	// DryRunFormatJSON prints the dry-run plan as JSON.
+	DryRunFormatJSON = "json"
+)
+
+// Placeholder for values that are only known once resources have been created.
+const planValuePending = "(assigned during create)"
+
+// The error returned by PreCreateCheck once the dry-run plan has been printed.
+var errDryRunComplete = errors.New("Dry run complete: the plan has been printed and no CloudControl resources were created (this is not a failure; 'docker-machine-driver-ddcloud plan' prints the same plan and exits with status 0)")
+
+// A plan of what Create would do.
+type createPlan struct {
+	MachineName   string             `json:"machine_name"`
+	Region        string             `json:"region"`
+	DataCenterID  string             `json:"datacenter"`
+	NetworkDomain planResource       `json:"network_domain"`
+	Server        serverPlan         `json:"server"`
+	PublicIP      publicIPPlan       `json:"public_ip"`
+	FirewallRules []firewallRulePlan `json:"firewall_rules"`
+	SSHBootstrap  sshBootstrapPlan   `json:"ssh_bootstrap"`
+}
+
+// A CloudControl resource referenced by a plan.
+type planResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// The planned target server.
+type serverPlan struct {
+	// "deploy" (a new server) or "adopt" (an existing server).
+	Action             string       `json:"action"`
+	ID                 string       `json:"id,omitempty"`
+	Name               string       `json:"name"`
+	Image              planImage    `json:"image"`
+	CPUCount           int          `json:"cpu_count"`
+	CoresPerSocket     int          `json:"cores_per_socket"`
+	CPUSpeed           string       `json:"cpu_speed,omitempty"`
+	MemoryGB           int          `json:"memory_gb"`
+	Disks              []diskPlan   `json:"disks"`
+	VLAN               planResource `json:"vlan"`
+	PrivateIPv4Address string       `json:"private_ipv4_address"`
+	PrimaryDNS         string       `json:"primary_dns,omitempty"`
+	SecondaryDNS       string       `json:"secondary_dns,omitempty"`
+}
+
+// The image for a planned server.
+type planImage struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+	OS   string `json:"os,omitempty"`
+}
+
+// A disk for a planned server.
+type diskPlan struct {
+	SCSIBusNumber int    `json:"scsi_bus_number"`
+	SCSIUnitID    int    `json:"scsi_unit_id"`
+	SizeGB        int    `json:"size_gb"`
+	Speed         string `json:"speed"`
+}
+
+// The planned public IP address (and NAT rule) for the target server.
+type publicIPPlan struct {
+	// Will the server be exposed via a NAT rule (false if --ddcloud-use-private-ip is specified)?
+	Enabled bool `json:"enabled"`
+
+	// "create" (a new NAT rule), "reuse" (an existing NAT rule), or "none".
+	NATRuleAction string `json:"nat_rule_action"`
+
+	// The Id of the existing NAT rule (if reused).
+	NATRuleID string `json:"nat_rule_id,omitempty"`
+
+	// The public IPv4 address.
+	ExternalIPAddress string `json:"external_ip_address,omitempty"`
+
+	// The number of public IP addresses currently available in the network domain.
+	AvailablePublicIPs int `json:"available_public_ips"`
+
+	// Would a new public IP block be allocated?
+	AllocatePublicIPBlock bool `json:"allocate_public_ip_block"`
+}
+
+// A planned firewall rule.
+type firewallRulePlan struct {
+	Name               string `json:"name"`
+	Action             string `json:"action"`
+	Protocol           string `json:"protocol"`
+	SourceAddress      string `json:"source_address"`
+	DestinationAddress string `json:"destination_address"`
+	DestinationPort    int    `json:"destination_port"`
+
+	// Is the rule deleted once SSH has been bootstrapped?
+	Temporary bool `json:"temporary"`
+}
+
+// The planned SSH bootstrap target.
+type sshBootstrapPlan struct {
+	Host            string `json:"host"`
+	Port            int    `json:"port"`
+	User            string `json:"user"`
+	Bastion         string `json:"bastion,omitempty"`
+	SSHUser         string `json:"ssh_user"`
+	SSHPort         int    `json:"ssh_port"`
+	SSHKey          string `json:"ssh_key"`
+	PermitRootLogin string `json:"permit_root_login,omitempty"`
+	HardenSSHServer bool   `json:"harden_sshd"`
+}
+
+// Build and print the dry-run plan (existingServer is the server to adopt, if any).
+//
+// Always returns an error (so that Docker Machine does not go on to create the machine).
+func (driver *Driver) printCreatePlan(existingServer *compute.Server) error {
+	err := driver.validateSSHKeyConfiguration()
+	if err != nil {
+		return err
+	}
+
+	plan, err := driver.buildCreatePlan(existingServer)
+	if err != nil {
+		return err
+	}
+
+	writer := driver.planWriter
+	if writer == nil {
+		writer = os.Stdout
+	}
+
+	if driver.DryRunFormat == DryRunFormatJSON {
+		err = plan.writeJSON(writer)
+	} else {
+		err = plan.writeText(writer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return errDryRunComplete
+}
+
+// Run "plan" with the specified command-line arguments.
+//
+// Prints the plan for "docker-machine create" with the same options (as if --ddcloud-dry-run had been specified), without creating a Docker Machine store entry.
+func runPlan(args []string, writer io.Writer) error {
+	// Progress messages (e.g. when resolving the network domain) must not be mixed with the plan.
+	log.SetOutWriter(os.Stderr)
+
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			SSHUser: "root",
+			SSHPort: 22,
+		},
+		planWriter: writer,
+	}
+
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	registerDriverFlags(flags, driver.GetCreateFlags())
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected exactly one machine name (usage: plan [options] <machine-name>)")
+	}
+	driver.MachineName = flags.Arg(0)
+
+	err = driver.SetConfigFromFlags(&commandLineDriverOptions{flags})
+	if err != nil {
+		return err
+	}
+	driver.DryRun = true
+
+	err = driver.PreCreateCheck()
+	if err == errDryRunComplete {
+		return nil
+	}
+
+	return err
+}
+
+// Validate the SSH key configuration (without importing or generating any keys).
+func (driver *Driver) validateSSHKeyConfiguration() error {
+	if driver.SSHUseAgent {
+		return driver.resolveSSHAgentIdentity()
+	}
+
+	if driver.SSHKey != "" {
+		_, err := os.Stat(driver.SSHKey)
+		if err != nil {
+			return fmt.Errorf("Unable to read SSH key '%s': %s", driver.SSHKey, err.Error())
+		}
+	}
+
+	if driver.SSHBastionKey != "" {
+		_, err := os.Stat(driver.SSHBastionKey)
+		if err != nil {
+			return fmt.Errorf("Unable to read SSH bastion key '%s': %s", driver.SSHBastionKey, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Build a plan of what Create would do.
+func (driver *Driver) buildCreatePlan(existingServer *compute.Server) (*createPlan, error) {
+	log.Infof("Building plan for machine '%s'...", driver.MachineName)
+
+	credentials, err := driver.getCloudControlCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &createPlan{
+		MachineName:  driver.MachineName,
+		Region:       credentials.Region,
+		DataCenterID: driver.DataCenterID,
+		NetworkDomain: planResource{
+			ID:   driver.NetworkDomainID,
+			Name: driver.NetworkDomainName,
+		},
+	}
+	if plan.Region == "" {
+		plan.Region = credentials.EndPointURI
+	}
+
+	if existingServer != nil {
+		plan.Server = planAdoptedServer(existingServer)
+	} else {
+		plan.Server, err = driver.planDeployedServer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plan.PublicIP, err = driver.planPublicIP(plan.Server.PrivateIPv4Address)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.FirewallRules, err = driver.planFirewallRules(plan.PublicIP.ExternalIPAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.SSHBootstrap = driver.planSSHBootstrap(plan)
+
+	return plan, nil
+}
+
+// Plan the deployment of a new server (from the configuration that would be passed to DeployServer).
+func (driver *Driver) planDeployedServer() (serverPlan, error) {
+	deploymentConfiguration, err := driver.buildDeploymentConfiguration()
+	if err != nil {
+		return serverPlan{}, err
+	}
+
+	server := serverPlan{
+		Action: "deploy",
+		Name:   deploymentConfiguration.Name,
+		Image: planImage{
+			ID:   driver.ImageID,
+			Name: driver.ImageName,
+			Type: compute.ImageTypeName(driver.ImageType),
+			OS:   driver.ImageOSType,
+		},
+		CPUCount:           deploymentConfiguration.CPU.Count,
+		CoresPerSocket:     deploymentConfiguration.CPU.CoresPerSocket,
+		CPUSpeed:           deploymentConfiguration.CPU.Speed,
+		MemoryGB:           deploymentConfiguration.MemoryGB,
+		Disks:              planDisks(deploymentConfiguration.SCSIControllers),
+		PrivateIPv4Address: planValuePending,
+		PrimaryDNS:         deploymentConfiguration.PrimaryDNS,
+		SecondaryDNS:       deploymentConfiguration.SecondaryDNS,
+	}
+
+	primaryAdapter := deploymentConfiguration.Network.PrimaryAdapter
+	if primaryAdapter.PrivateIPv4Address != nil {
+		server.PrivateIPv4Address = *primaryAdapter.PrivateIPv4Address
+	} else {
+		server.VLAN = planResource{
+			ID:   driver.VLANID,
+			Name: driver.VLANName,
+		}
+	}
+
+	return server, nil
+}
+
+// Plan the adoption of an existing server.
+func planAdoptedServer(existingServer *compute.Server) serverPlan {
+	server := serverPlan{
+		Action: "adopt",
+		ID:     existingServer.ID,
+		Name:   existingServer.Name,
+		Image: planImage{
+			ID: existingServer.SourceImageID,
+		},
+		CPUCount:       existingServer.CPU.Count,
+		CoresPerSocket: existingServer.CPU.CoresPerSocket,
+		CPUSpeed:       existingServer.CPU.Speed,
+		MemoryGB:       existingServer.MemoryGB,
+		Disks:          planDisks(existingServer.SCSIControllers),
+	}
+
+	primaryAdapter := existingServer.Network.PrimaryAdapter
+	if primaryAdapter.PrivateIPv4Address != nil {
+		server.PrivateIPv4Address = *primaryAdapter.PrivateIPv4Address
+	}
+	if primaryAdapter.VLANID != nil {
+		server.VLAN.ID = *primaryAdapter.VLANID
+	}
+	if primaryAdapter.VLANName != nil {
+		server.VLAN.Name = *primaryAdapter.VLANName
+	}
+
+	return server
+}
+
+// Plan a server's disks (attached to its SCSI controllers).
+func planDisks(controllers compute.VirtualMachineSCSIControllers) []diskPlan {
+	plannedDisks := make([]diskPlan, 0)
+	for _, controller := range controllers {
+		for _, disk := range controller.Disks {
+			plannedDisks = append(plannedDisks, diskPlan{
+				SCSIBusNumber: controller.BusNumber,
+				SCSIUnitID:    disk.SCSIUnitID,
+				SizeGB:        disk.SizeGB,
+				Speed:         disk.Speed,
+			})
+		}
+	}
+
+	return plannedDisks
+}
+
+// Plan the server's public IP address (and NAT rule).
+func (driver *Driver) planPublicIP(privateIPAddress string) (publicIPPlan, error) {
+	if driver.UsePrivateIP {
+		return publicIPPlan{NATRuleAction: "none"}, nil
+	}
+
+	publicIP := publicIPPlan{
+		Enabled:           true,
+		NATRuleAction:     "create",
+		ExternalIPAddress: planValuePending,
+	}
+
+	// An existing NAT rule can only be found if the private IP address is already known.
+	if privateIPAddress != planValuePending && privateIPAddress != "" {
+		natRule, err := driver.getExistingNATRuleByInternalIP(privateIPAddress)
+		if err != nil {
+			return publicIP, err
+		}
+		if natRule != nil {
+			publicIP.NATRuleAction = "reuse"
+			publicIP.NATRuleID = natRule.ID
+			publicIP.ExternalIPAddress = natRule.ExternalIPAddress
+
+			return publicIP, nil
+		}
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return publicIP, err
+	}
+
+	availableIPs, err := client.GetAvailablePublicIPAddresses(driver.NetworkDomainID)
+	if err != nil {
+		return publicIP, err
+	}
+	publicIP.AvailablePublicIPs = len(availableIPs)
+	publicIP.AllocatePublicIPBlock = len(availableIPs) == 0
+
+	return publicIP, nil
+}
+
+// Plan the firewall rules for the server.
+func (driver *Driver) planFirewallRules(externalIPAddress string) ([]firewallRulePlan, error) {
+	firewallRules := []firewallRulePlan{}
+	if driver.UsePrivateIP || !(driver.CreateSSHFirewallRule || driver.CreateDockerFirewallRule) {
+		return firewallRules, nil
+	}
+
+	clientPublicIPAddress := driver.ClientPublicIPAddress
+	if clientPublicIPAddress == "" {
+		var err error
+		clientPublicIPAddress, err = driver.getClientPublicIPv4Address()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addRule := func(suffix string, port int, temporary bool) {
+		firewallRules = append(firewallRules, firewallRulePlan{
+			Name:               driver.buildFirewallRuleName(suffix),
+			Action:             "accept",
+			Protocol:           "TCP",
+			SourceAddress:      clientPublicIPAddress,
+			DestinationAddress: externalIPAddress,
+			DestinationPort:    port,
+			Temporary:          temporary,
+		})
+	}
+
+	if driver.CreateSSHFirewallRule {
+		addRule("SSH", driver.SSHPort, false)
+
+		// SSH is always bootstrapped via the default port.
+		if driver.SSHPort != sshDefaultPort {
+			addRule("SSHBootstrap", sshDefaultPort, !driver.SSHKeepDefaultPort)
+		}
+	}
+
+	if driver.CreateDockerFirewallRule {
+		addRule("Docker", driver.getDockerPort(), false)
+	}
+
+	return firewallRules, nil
+}
+
+// Plan the SSH bootstrap process for the server.
+func (driver *Driver) planSSHBootstrap(plan *createPlan) sshBootstrapPlan {
+	sshBootstrap := sshBootstrapPlan{
+		Host:    plan.PublicIP.ExternalIPAddress,
+		Port:    sshDefaultPort,
+		User:    sshBootstrapUser,
+		Bastion: driver.SSHBastion,
+		SSHUser: driver.SSHUser,
+		SSHPort: driver.SSHPort,
+
+		HardenSSHServer: !driver.isAdoptingExistingServer() || driver.HardenAdoptedServer,
+	}
+	if !plan.PublicIP.Enabled {
+		sshBootstrap.Host = plan.Server.PrivateIPv4Address
+	}
+	if driver.SSHRestrictRootLogin {
+		sshBootstrap.PermitRootLogin = "prohibit-password"
+	}
+
+	switch {
+	case driver.SSHUseAgent:
+		sshBootstrap.SSHKey = fmt.Sprintf("ssh-agent identity '%s'", driver.SSHAgentIdentity)
+	case driver.SSHKey != "":
+		sshBootstrap.SSHKey = fmt.Sprintf("import '%s'", driver.SSHKey)
+	default:
+		sshBootstrap.SSHKey = fmt.Sprintf("generate %s key", driver.SSHKeyType)
+	}
+
+	return sshBootstrap
+}
+
+// Write the plan as JSON.
+func (plan *createPlan) writeJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(plan)
+}
+
+// Write the plan as text.
+func (plan *createPlan) writeText(writer io.Writer) error {
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(table, "Plan for machine '%s' (dry run; no changes will be made):\n", plan.MachineName)
+	fmt.Fprintf(table, "  Region:\t%s\n", plan.Region)
+	fmt.Fprintf(table, "  Data centre:\t%s\n", plan.DataCenterID)
+	fmt.Fprintf(table, "  Network domain:\t%s\n", describePlanResource(plan.NetworkDomain))
+
+	server := plan.Server
+	fmt.Fprintln(table, "Server:")
+	if server.Action == "adopt" {
+		fmt.Fprintf(table, "  Action:\tadopt existing server '%s' ('%s')\n", server.Name, server.ID)
+		fmt.Fprintf(table, "  Image:\t%s\n", server.Image.ID)
+	} else {
+		fmt.Fprintf(table, "  Action:\tdeploy new server '%s'\n", server.Name)
+		fmt.Fprintf(table, "  Image:\t%s image %s (OS: %s)\n", server.Image.Type, describePlanResource(planResource{server.Image.ID, server.Image.Name}), server.Image.OS)
+	}
+	fmt.Fprintf(table, "  CPU:\t%d (%d cores per socket, speed %s)\n", server.CPUCount, server.CoresPerSocket, server.CPUSpeed)
+	fmt.Fprintf(table, "  Memory:\t%d GB\n", server.MemoryGB)
+	for _, disk := range server.Disks {
+		fmt.Fprintf(table, "  Disk %d:%d:\t%d GB (%s)\n", disk.SCSIBusNumber, disk.SCSIUnitID, disk.SizeGB, disk.Speed)
+	}
+	if server.VLAN.ID != "" {
+		fmt.Fprintf(table, "  VLAN:\t%s\n", describePlanResource(server.VLAN))
+	}
+	fmt.Fprintf(table, "  Private IPv4 address:\t%s\n", server.PrivateIPv4Address)
+	if server.PrimaryDNS != "" {
+		fmt.Fprintf(table, "  DNS:\t%s, %s\n", server.PrimaryDNS, server.SecondaryDNS)
+	}
+
+	publicIP := plan.PublicIP
+	fmt.Fprintln(table, "Public IP:")
+	switch publicIP.NATRuleAction {
+	case "none":
+		fmt.Fprintln(table, "  NAT rule:\tnone (--ddcloud-use-private-ip)")
+	case "reuse":
+		fmt.Fprintf(table, "  NAT rule:\treuse existing NAT rule '%s'\n", publicIP.NATRuleID)
+	default:
+		fmt.Fprintln(table, "  NAT rule:\tcreate")
+		if publicIP.AllocatePublicIPBlock {
+			fmt.Fprintln(table, "  Public IP block:\tallocate new block (no public IPs available)")
+		} else {
+			fmt.Fprintf(table, "  Public IP block:\tnone required (%d public IPs available)\n", publicIP.AvailablePublicIPs)
+		}
+	}
+	if publicIP.Enabled {
+		fmt.Fprintf(table, "  Public IPv4 address:\t%s\n", publicIP.ExternalIPAddress)
+	}
+
+	fmt.Fprintln(table, "Firewall rules:")
+	if len(plan.FirewallRules) == 0 {
+		fmt.Fprintln(table, "  none")
+	}
+	for _, rule := range plan.FirewallRules {
+		temporary := ""
+		if rule.Temporary {
+			temporary = " (deleted after SSH bootstrap)"
+		}
+		fmt.Fprintf(table, "  %s:\t%s %s from %s to %s:%d%s\n",
+			rule.Name,
+			rule.Action,
+			rule.Protocol,
+			rule.SourceAddress,
+			rule.DestinationAddress,
+			rule.DestinationPort,
+			temporary,
+		)
+	}
+
+	sshBootstrap := plan.SSHBootstrap
+	fmt.Fprintln(table, "SSH bootstrap:")
+	fmt.Fprintf(table, "  Target:\t%s@%s:%d (password)\n", sshBootstrap.User, sshBootstrap.Host, sshBootstrap.Port)
+	if sshBootstrap.Bastion != "" {
+		fmt.Fprintf(table, "  Via bastion:\t%s\n", sshBootstrap.Bastion)
+	}
+	fmt.Fprintf(table, "  SSH key:\t%s\n", sshBootstrap.SSHKey)
+	fmt.Fprintf(table, "  Then connect as:\t%s (port %d)\n", sshBootstrap.SSHUser, sshBootstrap.SSHPort)
+	if sshBootstrap.PermitRootLogin != "" {
+		fmt.Fprintf(table, "  Root login:\t%s\n", sshBootstrap.PermitRootLogin)
+	}
+	if sshBootstrap.HardenSSHServer {
+		fmt.Fprintf(table, "  Harden sshd:\tlock %s password, disable password authentication\n", sshBootstrap.User)
+	} else {
+		fmt.Fprintln(table, "  Harden sshd:\tno (sshd configuration and root password are left unchanged)")
+	}
+
+	return table.Flush()
+}
+
+// Describe a resource referenced by a plan ("name ('id')").
+func describePlanResource(resource planResource) string {
+	if resource.Name == "" {
+		return fmt.Sprintf("'%s'", resource.ID)
+	}
+	if resource.ID == "" {
+		return fmt.Sprintf("'%s'", resource.Name)
+	}
+
+	return fmt.Sprintf("'%s' ('%s')", resource.Name, resource.ID)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Create a fake CloudControl API with everything required to plan a new machine.
+func newPlanTestCloudControl(t *testing.T) *fakeCloudControl {
+	t.Helper()
+
+	fake := newFakeCloudControl(t)
+	fake.Datacenters = []compute.Datacenter{
+		{ID: "AU9", DisplayName: "Sydney 2", City: "Sydney", Country: "Australia"},
+	}
+	fake.NetworkDomains = []compute.NetworkDomain{
+		{ID: "network-domain-1", Name: "Test", DatacenterID: "AU9", Type: "ESSENTIALS", State: compute.ResourceStatusNormal},
+	}
+	fake.VLANs = []compute.VLAN{
+		{
+			ID:            "vlan-1",
+			Name:          "Primary",
+			NetworkDomain: compute.EntityReference{ID: "network-domain-1"},
+			IPv4Range:     compute.IPv4Range{BaseAddress: "10.0.0.0", PrefixSize: 24},
+			State:         compute.ResourceStatusNormal,
+		},
+	}
+
+	osImage := compute.OSImage{ID: "os-image-1", Name: "Ubuntu 16.04 64-bit", DataCenterID: "AU9", MemoryGB: 8}
+	osImage.Guest.OperatingSystem.ID = "UBUNTU1664"
+	osImage.Guest.OperatingSystem.Family = "UNIX"
+	osImage.CPU.Count = 2
+	osImage.CPU.CoresPerSocket = 1
+	fake.OSImages = []compute.OSImage{osImage}
+
+	fake.PublicIPBlocks = []compute.PublicIPBlock{
+		{ID: "public-ip-block-1", NetworkDomainID: "network-domain-1", BaseIP: "203.0.113.1", Size: 2, State: compute.ResourceStatusNormal},
+	}
+
+	return fake
+}
+
+// Run "plan" against the fake CloudControl API.
+func runPlanAgainst(fake *fakeCloudControl, args ...string) (string, error) {
+	defer log.SetOutWriter(os.Stdout) // runPlan redirects progress messages to stderr.
+
+	output := &bytes.Buffer{}
+	err := runPlan(append([]string{
+		"--ddcloud-mcp-endpoint", fake.URL(),
+		"--ddcloud-mcp-user", "test-user",
+		"--ddcloud-mcp-password", "test-password",
+		"--ddcloud-datacenter", "AU9",
+		"--ddcloud-networkdomain", "Test",
+		"--ddcloud-vlan", "Primary",
+		"--ddcloud-image-name", "Ubuntu 16.04 64-bit",
+		"--ddcloud-client-public-ip", "198.51.100.1",
+	}, args...), output)
+
+	return output.String(), err
+}
+
+func TestRunPlanSucceedsWithoutChanges(t *testing.T) {
+	fake := newPlanTestCloudControl(t)
+
+	output, err := runPlanAgainst(fake, "--ddcloud-create-ssh-firewall-rule", "--ddcloud-dry-run-format", "json", "test-machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan createPlan
+	err = json.Unmarshal([]byte(output), &plan)
+	if err != nil {
+		t.Fatalf("Output is not valid JSON (%s):\n%s", err, output)
+	}
+	if plan.MachineName != "test-machine" || plan.DataCenterID != "AU9" || plan.NetworkDomain.ID != "network-domain-1" {
+		t.Errorf("Unexpected target (machine '%s', data centre '%s', network domain '%s')", plan.MachineName, plan.DataCenterID, plan.NetworkDomain.ID)
+	}
+	if plan.Server.Action != "deploy" || plan.Server.Image.ID != "os-image-1" || plan.Server.VLAN.ID != "vlan-1" {
+		t.Errorf("Unexpected server plan (action '%s', image '%s', VLAN '%s')", plan.Server.Action, plan.Server.Image.ID, plan.Server.VLAN.ID)
+	}
+	if plan.Server.CPUCount != 2 || plan.Server.MemoryGB != 8 {
+		t.Errorf("Unexpected server size (%d CPUs, %d GB)", plan.Server.CPUCount, plan.Server.MemoryGB)
+	}
+	if plan.PublicIP.NATRuleAction != "create" || plan.PublicIP.AvailablePublicIPs != 2 || plan.PublicIP.AllocatePublicIPBlock {
+		t.Errorf("Unexpected public IP plan %+v", plan.PublicIP)
+	}
+	if len(plan.FirewallRules) != 1 || plan.FirewallRules[0].SourceAddress != "198.51.100.1" {
+		t.Errorf("Unexpected firewall rules %+v (expected SSH rule from client public IP)", plan.FirewallRules)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Planning made changes (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestRunPlanRequiresMachineName(t *testing.T) {
+	fake := newPlanTestCloudControl(t)
+
+	_, err := runPlanAgainst(fake)
+	if err == nil || !strings.Contains(err.Error(), "machine name") {
+		t.Fatalf("Expected missing machine name error (got %v)", err)
+	}
+}
+
+func TestPreCreateCheckDryRunReportsNoChanges(t *testing.T) {
+	fake := newPlanTestCloudControl(t)
+
+	driver := fake.newDriver(t, "test-machine", "")
+	driver.DataCenterID = "AU9"
+	driver.NetworkDomainName = "Test"
+	driver.VLANName = "Primary"
+	driver.ImageName = "Ubuntu 16.04 64-bit"
+	driver.UsePrivateIP = true
+	driver.DryRun = true
+	output := &bytes.Buffer{}
+	driver.planWriter = output
+
+	// Docker Machine reports this error as a failed pre-create check (and does not go on to create the machine).
+	err := driver.PreCreateCheck()
+	if err != errDryRunComplete {
+		t.Fatalf("Expected dry run to complete (got %v)", err)
+	}
+	if !strings.Contains(err.Error(), "no CloudControl resources were created") {
+		t.Errorf("Dry run error does not say that no resources were created: %s", err)
+	}
+	if !strings.HasPrefix(output.String(), "Plan for machine 'test-machine'") {
+		t.Errorf("Unexpected plan:\n%s", output.String())
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Dry run made changes (%s)", strings.Join(operations, ", "))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+/*
+ * Adoption of existing servers
+ * ----------------------------
+ *
+ * Instead of deploying a new server, the driver can adopt an existing server (e.g. one built by another team, or by Terraform).
+ * The driver then creates (or adopts) NAT and firewall rules as configured, and bootstraps SSH using the server's existing root password.
+ *
+ * By default, removing the machine only detaches it (the server is left running, and only rules created by the driver are deleted).
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Is the driver configured to adopt an existing server?
+func (driver *Driver) isAdoptingExistingServer() bool {
+	return driver.ExistingServer != ""
+}
+
+// Find the existing server (by name or Id) in the target network domain.
+func (driver *Driver) findExistingServer() (*compute.Server, error) {
+	if driver.NetworkDomainID == "" {
+		return nil, errors.New("Network domain has not been resolved")
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var matchingServers []compute.Server
+
+	page := compute.DefaultPaging()
+	for {
+		var servers compute.Servers
+		servers, err = client.ListServersInNetworkDomain(driver.NetworkDomainID, page)
+		if err != nil {
+			return nil, err
+		}
+		if servers.IsEmpty() {
+			break // We're done
+		}
+
+		for _, server := range servers.Items {
+			if server.ID == driver.ExistingServer {
+				return &server, nil // Ids are unique.
+			}
+
+			if server.Name == driver.ExistingServer {
+				matchingServers = append(matchingServers, server)
+			}
+		}
+
+		page.Next()
+	}
+
+	switch len(matchingServers) {
+	case 0:
+		return nil, fmt.Errorf("No server with name or Id '%s' was found in network domain '%s' ('%s')",
+			driver.ExistingServer,
+			driver.NetworkDomainName,
+			driver.NetworkDomainID,
+		)
+	case 1:
+		return &matchingServers[0], nil
+	default:
+		return nil, fmt.Errorf("More than one server named '%s' was found in network domain '%s' ('%s'); specify the server Id instead",
+			driver.ExistingServer,
+			driver.NetworkDomainName,
+			driver.NetworkDomainID,
+		)
+	}
+}
+
+// Adopt the existing server (instead of deploying a new one).
+func (driver *Driver) adoptExistingServer() (*compute.Server, error) {
+	if driver.isServerCreated() {
+		return nil, fmt.Errorf("Server '%s' already exists (Id = '%s')", driver.MachineName, driver.ServerID)
+	}
+
+	server, err := driver.findExistingServer()
+	if err != nil {
+		return nil, err
+	}
+	if !server.Deployed {
+		return nil, fmt.Errorf("Server '%s' ('%s') has not been successfully deployed (status is '%s')", server.Name, server.ID, server.State)
+	}
+
+	primaryAdapter := server.Network.PrimaryAdapter
+	if primaryAdapter.PrivateIPv4Address == nil {
+		return nil, fmt.Errorf("Server '%s' ('%s') does not have a private IPv4 address", server.Name, server.ID)
+	}
+
+	driver.ServerID = server.ID
+	driver.ServerAdopted = true
+	driver.PrivateIPAddress = *primaryAdapter.PrivateIPv4Address
+	driver.IPAddress = driver.PrivateIPAddress // NAT rule not created yet.
+	if primaryAdapter.VLANID != nil {
+		driver.VLANID = *primaryAdapter.VLANID
+	}
+	if primaryAdapter.VLANName != nil {
+		driver.VLANName = *primaryAdapter.VLANName
+	}
+
+	log.Debugf("Adopted server '%s' ('%s') with private IP '%s' on VLAN '%s' ('%s').",
+		server.Name,
+		driver.ServerID,
+		driver.PrivateIPAddress,
+		driver.VLANName,
+		driver.VLANID,
+	)
+
+	if !server.Started {
+		log.Infof("Starting adopted server '%s'...", server.Name)
+
+		err = driver.startServer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return server, nil
+}
+
+// Detach the adopted server (deleting only the resources created by the driver, and leaving the server itself intact).
+func (driver *Driver) detachAdoptedServer() error {
+	log.Infof("Detaching adopted server '%s' ('%s'); the server will not be deleted.", driver.MachineName, driver.ServerID)
+
+	errs := &removalErrors{}
+	driver.removeNetworkResources(errs)
+	if len(errs.failures) == 0 {
+		driver.ServerID = "" // Record detachment.
+		driver.ServerAdopted = false
+	}
+
+	return driver.combineRemovalErrors(errs)
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+// The organisation Id reported by the fake CloudControl API.
+const fakeOrganizationID = "test-organization"
+
+// The number of addresses in each public IP block allocated by the fake CloudControl API.
+const fakePublicIPBlockSize = 2
+
+// A fake CloudControl API (implements just enough of the API to exercise the driver).
+type fakeCloudControl struct {
+	stateLock sync.Mutex
+	server    *httptest.Server
+	nextID    int
+
+	Datacenters    []compute.Datacenter
+	NetworkDomains []compute.NetworkDomain
+	VLANs          []compute.VLAN
+	OSImages       []compute.OSImage
+	CustomerImages []compute.CustomerImage
+	Servers        []*fakeServer
+	PublicIPBlocks []compute.PublicIPBlock
+	NATRules       []compute.NATRule
+
+	// Response codes with which to reject requests to create NAT rules (one per request) before creating them; used to simulate conflicting changes.
+	NATRuleConflicts []string
+
+	// The operations (e.g. "server/startServer") that have been requested, in order.
+	Operations []string
+}
+
+// A server in the fake CloudControl API.
+type fakeServer struct {
+	compute.Server
+
+	// Statuses to report (one per request for the server) before its actual status; used to simulate operations in progress.
+	ScriptedStates []string
+
+	// The progress of the server's current (or last failed) operation, if any.
+	Progress *serverProgress
+
+	// The status the server is left in by a request to shut it down (if not specified, it shuts down immediately).
+	//
+	// If PENDING_CHANGE, the shutdown remains in progress until the server is powered off.
+	ShutdownState string
+}
+
+// Create and start a new fake CloudControl API (closed when the test completes).
+func newFakeCloudControl(t *testing.T) *fakeCloudControl {
+	t.Helper()
+
+	fake := &fakeCloudControl{}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handleRequest))
+	t.Cleanup(fake.server.Close)
+
+	return fake
+}
+
+// The base address of the fake CloudControl API.
+func (fake *fakeCloudControl) URL() string {
+	return fake.server.URL
+}
+
+// Create a new driver that targets the fake CloudControl API.
+func (fake *fakeCloudControl) newDriver(t *testing.T, machineName string, storePath string) *Driver {
+	t.Helper()
+
+	if storePath == "" {
+		storePath = t.TempDir()
+	}
+
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: machineName,
+			StorePath:   storePath,
+		},
+		CloudControlUser:        "test-user",
+		CloudControlPassword:    "test-password",
+		CloudControlEndPointURI: fake.URL(),
+	}
+
+	// The machine folder (where the audit log is written) is normally created by Docker Machine.
+	err := os.MkdirAll(driver.ResolveStorePath("."), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return driver
+}
+
+// Add a server to the fake CloudControl API.
+func (fake *fakeCloudControl) addServer(server compute.Server, scriptedStates ...string) *fakeServer {
+	fake.stateLock.Lock()
+	defer fake.stateLock.Unlock()
+
+	if server.ID == "" {
+		server.ID = fake.newID("server")
+	}
+	fakeServer := &fakeServer{Server: server, ScriptedStates: scriptedStates}
+	fake.Servers = append(fake.Servers, fakeServer)
+
+	return fakeServer
+}
+
+// Retrieve the operations that have been requested.
+func (fake *fakeCloudControl) getOperations() []string {
+	fake.stateLock.Lock()
+	defer fake.stateLock.Unlock()
+
+	return append([]string(nil), fake.Operations...)
+}
+
+// Generate a new resource Id.
+func (fake *fakeCloudControl) newID(prefix string) string {
+	fake.nextID++
+
+	return fmt.Sprintf("%s-%d", prefix, fake.nextID)
+}
+
+// Handle a request to the fake CloudControl API.
+func (fake *fakeCloudControl) handleRequest(writer http.ResponseWriter, request *http.Request) {
+	fake.stateLock.Lock()
+	defer fake.stateLock.Unlock()
+
+	if request.URL.Path == "/oec/0.9/myaccount" {
+		writer.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(writer, "<Account><userName>test-user</userName><orgId>%s</orgId></Account>", fakeOrganizationID)
+
+		return
+	}
+
+	// e.g. /caas/2.4/{organizationId}/server/server/{id}
+	pathSegments := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 4)
+	if len(pathSegments) != 4 || pathSegments[0] != "caas" || pathSegments[2] != fakeOrganizationID {
+		fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeInvalidInputData, fmt.Sprintf("Unexpected request path '%s'.", request.URL.Path))
+
+		return
+	}
+	operation := pathSegments[3]
+	query := request.URL.Query()
+
+	if request.Method == http.MethodPost {
+		fake.Operations = append(fake.Operations, operation)
+
+		var requestBody map[string]string
+		err := json.NewDecoder(request.Body).Decode(&requestBody)
+		if err != nil {
+			fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeInvalidInputData, fmt.Sprintf("Invalid request body: %s", err))
+
+			return
+		}
+
+		fake.handleOperation(writer, operation, requestBody)
+
+		return
+	}
+
+	switch {
+	case operation == "infrastructure/datacenter":
+		items := fake.Datacenters
+		if query.Get("id") != "" {
+			items = nil
+			for _, datacenter := range fake.Datacenters {
+				if datacenter.ID == query.Get("id") {
+					items = append(items, datacenter)
+				}
+			}
+		}
+		result := &compute.Datacenters{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.Items = append(result.Items, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case operation == "network/networkDomain":
+		var items []compute.NetworkDomain
+		for _, domain := range fake.NetworkDomains {
+			if matchesFakeQuery(query, "name", domain.Name) && matchesFakeQuery(query, "datacenterId", domain.DatacenterID) {
+				items = append(items, domain)
+			}
+		}
+		result := &compute.NetworkDomains{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.Domains = append(result.Domains, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case operation == "network/vlan":
+		var items []compute.VLAN
+		for _, vlan := range fake.VLANs {
+			if matchesFakeQuery(query, "name", vlan.Name) && matchesFakeQuery(query, "networkDomainId", vlan.NetworkDomain.ID) {
+				items = append(items, vlan)
+			}
+		}
+		result := &compute.VLANs{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.VLANs = append(result.VLANs, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case operation == "image/osImage":
+		var items []compute.OSImage
+		for _, image := range fake.OSImages {
+			if matchesFakeQuery(query, "name", image.Name) && matchesFakeQuery(query, "datacenterId", image.DataCenterID) {
+				items = append(items, image)
+			}
+		}
+		result := &compute.OSImages{}
+		page := getFakePage(query, len(items), func(index int) {
+			result.Images = append(result.Images, items[index])
+		})
+		result.PageNumber, result.PageCount, result.TotalCount, result.PageSize = page.PageNumber, page.PageCount, page.TotalCount, page.PageSize
+		fake.writeJSON(writer, result)
+
+	case operation == "image/customerImage":
+		var items []compute.CustomerImage
+		for _, image := range fake.CustomerImages {
+			if matchesFakeQuery(query, "name", image.Name) && matchesFakeQuery(query, "datacenterId", image.DataCenterID) {
+				items = append(items, image)
+			}
+		}
+		result := &compute.CustomerImages{}
+		page := getFakePage(query, len(items), func(index int) {
+			result.Images = append(result.Images, items[index])
+		})
+		result.PageNumber, result.PageCount, result.TotalCount, result.PageSize = page.PageNumber, page.PageCount, page.TotalCount, page.PageSize
+		fake.writeJSON(writer, result)
+
+	case strings.HasPrefix(operation, "image/osImage/"):
+		imageID := strings.TrimPrefix(operation, "image/osImage/")
+		for _, image := range fake.OSImages {
+			if image.ID == imageID {
+				fake.writeJSON(writer, image)
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusNotFound, compute.ResponseCodeResourceNotFound, "OS image not found.")
+
+	case strings.HasPrefix(operation, "image/customerImage/"):
+		imageID := strings.TrimPrefix(operation, "image/customerImage/")
+		for _, image := range fake.CustomerImages {
+			if image.ID == imageID {
+				fake.writeJSON(writer, image)
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusNotFound, compute.ResponseCodeResourceNotFound, "Customer image not found.")
+
+	case operation == "server/server":
+		var items []compute.Server
+		for _, server := range fake.Servers {
+			if matchesFakeQuery(query, "networkDomainId", server.Network.NetworkDomainID) {
+				items = append(items, server.Server)
+			}
+		}
+		result := &compute.Servers{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.Items = append(result.Items, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case strings.HasPrefix(operation, "server/server/"):
+		server := fake.findServer(strings.TrimPrefix(operation, "server/server/"))
+		if server == nil {
+			fake.writeResponse(writer, http.StatusNotFound, compute.ResponseCodeResourceNotFound, "Server not found.")
+
+			return
+		}
+
+		serverState := server.Server
+		if len(server.ScriptedStates) != 0 {
+			serverState.State = server.ScriptedStates[0]
+			server.ScriptedStates = server.ScriptedStates[1:]
+		}
+		fake.writeJSON(writer, struct {
+			compute.Server
+			Progress *serverProgress `json:"progress,omitempty"`
+		}{serverState, server.Progress})
+
+	case operation == "network/natRule":
+		var items []compute.NATRule
+		for _, rule := range fake.NATRules {
+			if matchesFakeQuery(query, "networkDomainId", rule.NetworkDomainID) {
+				items = append(items, rule)
+			}
+		}
+		result := &compute.NATRules{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.Rules = append(result.Rules, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case strings.HasPrefix(operation, "network/natRule/"):
+		ruleID := strings.TrimPrefix(operation, "network/natRule/")
+		for _, rule := range fake.NATRules {
+			if rule.ID == ruleID {
+				fake.writeJSON(writer, rule)
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusNotFound, compute.ResponseCodeResourceNotFound, "NAT rule not found.")
+
+	case operation == "network/publicIpBlock":
+		var items []compute.PublicIPBlock
+		for _, block := range fake.PublicIPBlocks {
+			if matchesFakeQuery(query, "networkDomainId", block.NetworkDomainID) {
+				items = append(items, block)
+			}
+		}
+		result := &compute.PublicIPBlocks{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.Blocks = append(result.Blocks, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	case strings.HasPrefix(operation, "network/publicIpBlock/"):
+		blockID := strings.TrimPrefix(operation, "network/publicIpBlock/")
+		for _, block := range fake.PublicIPBlocks {
+			if block.ID == blockID {
+				fake.writeJSON(writer, block)
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusNotFound, compute.ResponseCodeResourceNotFound, "Public IP block not found.")
+
+	case operation == "network/reservedPublicIpv4Address":
+		// Public IP addresses are reserved when they are used by NAT rules.
+		var items []compute.ReservedPublicIP
+		for _, rule := range fake.NATRules {
+			if matchesFakeQuery(query, "networkDomainId", rule.NetworkDomainID) {
+				items = append(items, compute.ReservedPublicIP{
+					IPBlockID:       fake.findPublicIPBlockID(rule.ExternalIPAddress),
+					NetworkDomainID: rule.NetworkDomainID,
+					Address:         rule.ExternalIPAddress,
+				})
+			}
+		}
+		result := &compute.ReservedPublicIPs{}
+		result.PagedResult = getFakePage(query, len(items), func(index int) {
+			result.IPs = append(result.IPs, items[index])
+		})
+		fake.writeJSON(writer, result)
+
+	default:
+		fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeInvalidInputData, fmt.Sprintf("Unsupported operation '%s'.", operation))
+	}
+}
+
+// Handle a request to perform an operation (POST) in the fake CloudControl API.
+func (fake *fakeCloudControl) handleOperation(writer http.ResponseWriter, operation string, requestBody map[string]string) {
+	switch operation {
+	case "server/startServer", "server/shutdownServer", "server/powerOffServer", "server/rebootServer":
+		server := fake.findServer(requestBody["id"])
+		if server == nil {
+			fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeResourceNotFound, "Server not found.")
+
+			return
+		}
+		isShutdownInProgress := server.State == compute.ResourceStatusPendingChange && server.ShutdownState == compute.ResourceStatusPendingChange
+		isBusy := server.State != compute.ResourceStatusNormal || len(server.ScriptedStates) != 0
+		if isBusy && !(operation == "server/powerOffServer" && isShutdownInProgress) {
+			fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeResourceBusy, "Server is busy.")
+
+			return
+		}
+
+		// The change is applied immediately (so the driver's first poll sees it completed), unless the server is scripted to do otherwise.
+		if operation == "server/shutdownServer" && server.ShutdownState != "" {
+			server.State = server.ShutdownState
+		} else {
+			server.State = compute.ResourceStatusNormal
+			server.Started = operation == "server/startServer" || operation == "server/rebootServer"
+		}
+		fake.writeResponse(writer, http.StatusOK, compute.ResponseCodeInProgress, "Request to change server power state has been accepted.")
+
+	case "network/addPublicIpBlock":
+		networkDomainID := requestBody["networkDomainId"]
+		block := compute.PublicIPBlock{
+			ID:              fake.newID("publicIpBlock"),
+			NetworkDomainID: networkDomainID,
+			BaseIP:          fmt.Sprintf("203.0.113.%d", len(fake.PublicIPBlocks)*fakePublicIPBlockSize+1),
+			Size:            fakePublicIPBlockSize,
+			State:           compute.ResourceStatusNormal,
+		}
+		fake.PublicIPBlocks = append(fake.PublicIPBlocks, block)
+		fake.writeResponse(writer, http.StatusOK, compute.ResponseCodeOK, "Public IP block has been added.", compute.FieldMessage{
+			FieldName: "ipBlockId",
+			Message:   block.ID,
+		})
+
+	case "network/removePublicIpBlock":
+		for index, block := range fake.PublicIPBlocks {
+			if block.ID == requestBody["id"] {
+				fake.PublicIPBlocks = append(fake.PublicIPBlocks[:index], fake.PublicIPBlocks[index+1:]...)
+				fake.writeResponse(writer, http.StatusOK, compute.ResponseCodeOK, "Public IP block has been removed.")
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeResourceNotFound, "Public IP block not found.")
+
+	case "network/createNatRule":
+		if len(fake.NATRuleConflicts) != 0 {
+			responseCode := fake.NATRuleConflicts[0]
+			fake.NATRuleConflicts = fake.NATRuleConflicts[1:]
+			fake.writeResponse(writer, http.StatusBadRequest, responseCode, "Unable to create NAT rule due to a conflicting change.")
+
+			return
+		}
+
+		networkDomainID := requestBody["networkDomainId"]
+		externalIP := fake.findAvailablePublicIP(networkDomainID)
+		if externalIP == "" {
+			fake.writeResponse(writer, http.StatusBadRequest, responseCodeNoIPAddressAvailable, fmt.Sprintf("No public IP addresses are available in network domain '%s'.", networkDomainID))
+
+			return
+		}
+
+		rule := compute.NATRule{
+			ID:                fake.newID("natRule"),
+			NetworkDomainID:   networkDomainID,
+			InternalIPAddress: requestBody["internalIp"],
+			ExternalIPAddress: externalIP,
+			State:             compute.ResourceStatusNormal,
+		}
+		fake.NATRules = append(fake.NATRules, rule)
+		fake.writeResponse(writer, http.StatusOK, compute.ResponseCodeOK, "NAT rule has been created.", compute.FieldMessage{
+			FieldName: "natRuleId",
+			Message:   rule.ID,
+		})
+
+	case "network/deleteNatRule":
+		for index, rule := range fake.NATRules {
+			if rule.ID == requestBody["id"] {
+				fake.NATRules = append(fake.NATRules[:index], fake.NATRules[index+1:]...)
+				fake.writeResponse(writer, http.StatusOK, compute.ResponseCodeOK, "NAT rule has been deleted.")
+
+				return
+			}
+		}
+		fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeResourceNotFound, "NAT rule not found.")
+
+	default:
+		fake.writeResponse(writer, http.StatusBadRequest, compute.ResponseCodeInvalidInputData, fmt.Sprintf("Unsupported operation '%s'.", operation))
+	}
+}
+
+// Find the server (if any) with the specified Id.
+func (fake *fakeCloudControl) findServer(id string) *fakeServer {
+	for _, server := range fake.Servers {
+		if server.ID == id {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// Find the Id of the public IP block (if any) containing the specified address.
+func (fake *fakeCloudControl) findPublicIPBlockID(address string) string {
+	for _, block := range fake.PublicIPBlocks {
+		for _, blockAddress := range getFakePublicIPBlockAddresses(block) {
+			if blockAddress == address {
+				return block.ID
+			}
+		}
+	}
+
+	return ""
+}
+
+// Find the first public IP address (if any) in the specified network domain that is not used by a NAT rule.
+func (fake *fakeCloudControl) findAvailablePublicIP(networkDomainID string) string {
+	usedAddresses := make(map[string]bool)
+	for _, rule := range fake.NATRules {
+		usedAddresses[rule.ExternalIPAddress] = true
+	}
+
+	for _, block := range fake.PublicIPBlocks {
+		if block.NetworkDomainID != networkDomainID {
+			continue
+		}
+
+		for _, address := range getFakePublicIPBlockAddresses(block) {
+			if !usedAddresses[address] {
+				return address
+			}
+		}
+	}
+
+	return ""
+}
+
+// Write a JSON response.
+func (fake *fakeCloudControl) writeJSON(writer http.ResponseWriter, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(body)
+}
+
+// Write an API (v2) response.
+func (fake *fakeCloudControl) writeResponse(writer http.ResponseWriter, statusCode int, responseCode string, message string, fieldMessages ...compute.FieldMessage) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	json.NewEncoder(writer).Encode(&compute.APIResponseV2{
+		ResponseCode:  responseCode,
+		Message:       message,
+		FieldMessages: fieldMessages,
+		RequestID:     "test-request",
+	})
+}
+
+// Determine whether the specified query parameter (if supplied) matches a value.
+func matchesFakeQuery(query map[string][]string, name string, value string) bool {
+	values, ok := query[name]
+
+	return !ok || len(values) == 0 || values[0] == value
+}
+
+// Select the items (by index) for the page requested by the specified query.
+//
+// Like the real API, pages past the last one are empty (with a page count of 0).
+func getFakePage(query map[string][]string, itemCount int, addItem func(index int)) compute.PagedResult {
+	page := compute.PagedResult{
+		PageNumber: 1,
+		PageSize:   50, // Same as compute.DefaultPaging().
+		TotalCount: itemCount,
+	}
+	if values := query["pageNumber"]; len(values) != 0 {
+		page.PageNumber, _ = strconv.Atoi(values[0])
+	}
+	if values := query["pageSize"]; len(values) != 0 {
+		page.PageSize, _ = strconv.Atoi(values[0])
+	}
+
+	for index := (page.PageNumber - 1) * page.PageSize; index < itemCount && index < page.PageNumber*page.PageSize; index++ {
+		addItem(index)
+		page.PageCount++
+	}
+
+	return page
+}
+
+// Get the addresses in a public IP block.
+func getFakePublicIPBlockAddresses(block compute.PublicIPBlock) []string {
+	baseAddress := binary.BigEndian.Uint32(net.ParseIP(block.BaseIP).To4())
+
+	addresses := make([]string, block.Size)
+	for index := range addresses {
+		address := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(address, baseAddress+uint32(index))
+		addresses[index] = address.String()
+	}
+
+	return addresses
+}
@@ -0,0 +1,105 @@
+package main
+
+/*
+ * Cross-process file locks
+ * ------------------------
+ *
+ * Used to serialise operations (e.g. public IP allocation and NAT rule creation) across concurrent driver processes.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// The folder (in the machine store root) containing lock files.
+const lockFolderName = "ddcloud-locks"
+
+// Lock timing.
+const (
+	// The maximum time to wait for a lock.
+	lockTimeout = 15 * time.Minute
+
+	// The period of time between attempts to acquire a lock.
+	lockRetryPeriod = 500 * time.Millisecond
+)
+
+// An exclusive lock on a file.
+type fileLock struct {
+	file *os.File
+}
+
+// Acquire an exclusive lock on the specified file (creating it if required), waiting up to the specified timeout.
+func acquireFileLock(lockFile string, timeout time.Duration) (*fileLock, error) {
+	err := os.MkdirAll(filepath.Dir(lockFile), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		locked, err = tryLockFile(file)
+		if err != nil {
+			file.Close()
+
+			return nil, err
+		}
+		if locked {
+			return &fileLock{file: file}, nil
+		}
+
+		if time.Now().After(deadline) {
+			file.Close()
+
+			return nil, fmt.Errorf("Timed out after %s waiting for lock '%s'", timeout, lockFile)
+		}
+
+		time.Sleep(lockRetryPeriod)
+	}
+}
+
+// Release the lock.
+func (lock *fileLock) Release() error {
+	if lock == nil {
+		return nil
+	}
+
+	err := unlockFile(lock.file)
+	closeErr := lock.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// Acquire the lock for the target network domain (serialises public IP allocation and NAT rule creation across concurrent driver processes).
+func (driver *Driver) lockNetworkDomain() (*fileLock, error) {
+	if driver.BaseDriver == nil || driver.StorePath == "" {
+		// Without a lock, concurrent driver processes could allocate duplicate public IP blocks or NAT rules.
+		return nil, fmt.Errorf("Cannot lock network domain '%s' (no machine store path has been configured)", driver.NetworkDomainID)
+	}
+
+	lockFile := filepath.Join(driver.StorePath, lockFolderName, driver.NetworkDomainID+".lock")
+
+	log.Debugf("Acquiring lock for network domain '%s' ('%s')...", driver.NetworkDomainID, lockFile)
+
+	lock, err := acquireFileLock(lockFile, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Acquired lock for network domain '%s'.", driver.NetworkDomainID)
+
+	return lock, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+func TestLockNetworkDomainRequiresStorePath(t *testing.T) {
+	driver := &Driver{
+		BaseDriver:      &drivers.BaseDriver{MachineName: "test-machine"},
+		NetworkDomainID: "test-network-domain",
+	}
+
+	lock, err := driver.lockNetworkDomain()
+	if err == nil {
+		lock.Release()
+
+		t.Fatal("Network domain was locked without a machine store path")
+	}
+	if !strings.Contains(err.Error(), "test-network-domain") {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestCreateNATRuleForServerIsSerialisedAcrossDrivers(t *testing.T) {
+	const serverCount = 7
+
+	fake := newFakeCloudControl(t)
+	storePath := t.TempDir() // Shared by all drivers (as it is by concurrent docker-machine processes).
+
+	machineDrivers := make([]*Driver, serverCount)
+	for index := range machineDrivers {
+		driver := fake.newDriver(t, fmt.Sprintf("test-machine-%d", index), storePath)
+		driver.NetworkDomainID = "test-network-domain"
+		driver.ServerID = fmt.Sprintf("test-server-%d", index)
+		driver.PrivateIPAddress = fmt.Sprintf("10.0.0.%d", index+10)
+
+		machineDrivers[index] = driver
+	}
+
+	var waitGroup sync.WaitGroup
+	errs := make([]error, serverCount)
+	for index, driver := range machineDrivers {
+		waitGroup.Add(1)
+		go func(index int, driver *Driver) {
+			defer waitGroup.Done()
+
+			errs[index] = driver.createNATRuleForServer()
+		}(index, driver)
+	}
+	waitGroup.Wait()
+
+	externalIPs := make(map[string]string)
+	for index, driver := range machineDrivers {
+		if errs[index] != nil {
+			t.Fatalf("Failed to create NAT rule for '%s': %s", driver.MachineName, errs[index])
+		}
+
+		if otherMachineName, ok := externalIPs[driver.IPAddress]; ok {
+			t.Errorf("'%s' and '%s' were both assigned external IP '%s'", driver.MachineName, otherMachineName, driver.IPAddress)
+		}
+		externalIPs[driver.IPAddress] = driver.MachineName
+	}
+
+	if len(fake.NATRules) != serverCount {
+		t.Errorf("%d NAT rules were created (expected %d)", len(fake.NATRules), serverCount)
+	}
+
+	// Each block has 2 addresses, so 7 servers need 4 blocks; any more means that 2 drivers allocated a block for the same address.
+	expectedBlockCount := (serverCount + fakePublicIPBlockSize - 1) / fakePublicIPBlockSize
+	if len(fake.PublicIPBlocks) != expectedBlockCount {
+		t.Errorf("%d public IP blocks were allocated (expected %d)", len(fake.PublicIPBlocks), expectedBlockCount)
+	}
+
+	var allocatedBlockCount int
+	for _, driver := range machineDrivers {
+		if driver.PublicIPBlockID != "" {
+			allocatedBlockCount++
+		}
+	}
+	if allocatedBlockCount != expectedBlockCount {
+		t.Errorf("%d drivers recorded allocating a public IP block (expected %d)", allocatedBlockCount, expectedBlockCount)
+	}
+}
+
+func TestCreateNATRuleForServerAllocatesSingleBlockWhenRequired(t *testing.T) {
+	fake := newFakeCloudControl(t)
+	storePath := t.TempDir()
+
+	var waitGroup sync.WaitGroup
+	errs := make([]error, fakePublicIPBlockSize)
+	machineDrivers := make([]*Driver, fakePublicIPBlockSize)
+	for index := range machineDrivers {
+		driver := fake.newDriver(t, fmt.Sprintf("test-machine-%d", index), storePath)
+		driver.NetworkDomainID = "test-network-domain"
+		driver.ServerID = fmt.Sprintf("test-server-%d", index)
+		driver.PrivateIPAddress = fmt.Sprintf("10.0.0.%d", index+10)
+		machineDrivers[index] = driver
+
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+
+			errs[index] = machineDrivers[index].createNATRuleForServer()
+		}(index)
+	}
+	waitGroup.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			t.Fatalf("Failed to create NAT rule for '%s': %s", machineDrivers[index].MachineName, err)
+		}
+	}
+	if machineDrivers[0].IPAddress == machineDrivers[1].IPAddress {
+		t.Fatalf("Both servers were assigned external IP '%s'", machineDrivers[0].IPAddress)
+	}
+	if len(fake.PublicIPBlocks) != 1 {
+		t.Fatalf("%d public IP blocks were allocated (expected 1)", len(fake.PublicIPBlocks))
+	}
+}
+
+// Create a driver that creates a NAT rule in the fake CloudControl API (retrying without delay).
+func newNATRuleTestDriver(t *testing.T, fake *fakeCloudControl) *Driver {
+	t.Helper()
+
+	driver := fake.newDriver(t, "test-machine", "")
+	driver.NetworkDomainID = "test-network-domain"
+	driver.ServerID = "test-server"
+	driver.PrivateIPAddress = "10.0.0.10"
+	driver.natRuleRetryPeriod = time.Millisecond
+
+	return driver
+}
+
+// Count the requests to create NAT rules.
+func countNATRuleAttempts(fake *fakeCloudControl) int {
+	var attempts int
+	for _, operation := range fake.getOperations() {
+		if operation == "network/createNatRule" {
+			attempts++
+		}
+	}
+
+	return attempts
+}
+
+func TestCreateNATRuleForServerRetriesConflicts(t *testing.T) {
+	fake := newFakeCloudControl(t)
+	fake.NATRuleConflicts = []string{compute.ResponseCodeResourceBusy, responseCodeNoIPAddressAvailable}
+	driver := newNATRuleTestDriver(t, fake)
+
+	err := driver.createNATRuleForServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.NATRuleID == "" || driver.IPAddress == "" {
+		t.Fatal("NAT rule was not recorded after retrying")
+	}
+
+	attempts := countNATRuleAttempts(fake)
+	if attempts != 3 {
+		t.Fatalf("NAT rule creation was attempted %d times (expected 3)", attempts)
+	}
+}
+
+func TestCreateNATRuleForServerGivesUpAfterMaxAttempts(t *testing.T) {
+	fake := newFakeCloudControl(t)
+	for attempt := 0; attempt <= natRuleMaxAttempts; attempt++ {
+		fake.NATRuleConflicts = append(fake.NATRuleConflicts, compute.ResponseCodeResourceBusy)
+	}
+	driver := newNATRuleTestDriver(t, fake)
+
+	err := driver.createNATRuleForServer()
+	if err == nil {
+		t.Fatal("NAT rule was created despite conflicts")
+	}
+	if !compute.IsResourceBusyError(err) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	attempts := countNATRuleAttempts(fake)
+	if attempts != natRuleMaxAttempts {
+		t.Fatalf("NAT rule creation was attempted %d times (expected %d)", attempts, natRuleMaxAttempts)
+	}
+}
+
+func TestCreateNATRuleForServerDoesNotRetryOtherErrors(t *testing.T) {
+	fake := newFakeCloudControl(t)
+	fake.NATRuleConflicts = []string{compute.ResponseCodeInvalidInputData}
+	driver := newNATRuleTestDriver(t, fake)
+
+	err := driver.createNATRuleForServer()
+	if err == nil {
+		t.Fatal("NAT rule was created despite invalid request")
+	}
+
+	attempts := countNATRuleAttempts(fake)
+	if attempts != 1 {
+		t.Fatalf("NAT rule creation was attempted %d times (expected 1)", attempts)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+/*
+ * Cross-process file locks (non-Windows)
+ * --------------------------------------
+ */
+
+import (
+	"os"
+	"syscall"
+)
+
+// Attempt to acquire an exclusive lock on a file (without blocking).
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release the lock on a file.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,42 @@
+//go:build windows
+// +build windows
+
+package main
+
+/*
+ * Cross-process file locks (Windows)
+ * ----------------------------------
+ */
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Attempt to acquire an exclusive lock on a file (without blocking).
+func tryLockFile(file *os.File) (bool, error) {
+	err := windows.LockFileEx(
+		windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0,
+		&windows.Overlapped{},
+	)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release the lock on a file.
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(
+		windows.Handle(file.Fd()),
+		0, 1, 0,
+		&windows.Overlapped{},
+	)
+}
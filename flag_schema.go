@@ -0,0 +1,189 @@
+package main
+
+/*
+ * Flag schema
+ * -----------
+ *
+ * "docker-machine-driver-ddcloud --describe-flags" prints a JSON description of the driver's create flags (for use by UI integrations, such as the Rancher UI plugin).
+ *
+ * Every create flag must have an entry in createFlagMetadata; describing the flags fails if any flag is missing one.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+// Flag types (as reported in the flag schema).
+const (
+	flagTypeString      = "string"
+	flagTypeInt         = "int"
+	flagTypeBool        = "bool"
+	flagTypeStringSlice = "string-slice"
+)
+
+// Additional metadata for a create flag (not captured by mcnflag).
+type flagMetadata struct {
+	// Must a value be supplied for the flag?
+	//
+	// Flags that are only required in some cases (e.g. ddcloud-vlan) are not marked as required; their usage describes when they are needed.
+	Required bool
+
+	// Is the flag's value a secret (e.g. a password) that should not be displayed?
+	Secret bool
+
+	// The values (if restricted) that the flag accepts.
+	AllowedValues []string
+}
+
+// Metadata for the driver's create flags (keyed by flag name).
+var createFlagMetadata = map[string]flagMetadata{
+	"ddcloud-profile":                     {},
+	"ddcloud-credentials-file":            {},
+	"ddcloud-config-key-file":             {},
+	"ddcloud-mcp-user":                    {},
+	"ddcloud-mcp-password":                {Secret: true},
+	"ddcloud-mcp-region":                  {},
+	"ddcloud-mcp-endpoint":                {},
+	"ddcloud-mcp-proxy":                   {Secret: true}, // May contain proxy credentials.
+	"ddcloud-mcp-ca-cert":                 {},
+	"ddcloud-mcp-client-cert":             {},
+	"ddcloud-mcp-client-key":              {},
+	"ddcloud-mcp-insecure-skip-verify":    {},
+	"ddcloud-networkdomain":               {Required: true},
+	"ddcloud-datacenter":                  {},
+	"ddcloud-vlan":                        {}, // Not required with --ddcloud-private-ipv4 or --ddcloud-existing-server.
+	"ddcloud-private-ipv4":                {},
+	"ddcloud-image-name":                  {},
+	"ddcloud-ssh-user":                    {},
+	"ddcloud-ssh-key":                     {},
+	"ddcloud-ssh-key-type":                {AllowedValues: []string{SSHKeyTypeRSA4096, SSHKeyTypeECDSA, SSHKeyTypeED25519}},
+	"ddcloud-ssh-use-agent":               {},
+	"ddcloud-ssh-agent-identity":          {},
+	"ddcloud-ssh-port":                    {},
+	"ddcloud-ssh-keep-default-port":       {},
+	"ddcloud-ssh-ready-timeout":           {},
+	"ddcloud-ssh-bootstrap-password":      {Secret: true},
+	"ddcloud-ssh-bastion":                 {},
+	"ddcloud-ssh-bastion-key":             {},
+	"ddcloud-docker-port-forward":         {},
+	"ddcloud-ssh-host-key-fingerprint":    {},
+	"ddcloud-ssh-restrict-root-login":     {},
+	"ddcloud-create-ssh-firewall-rule":    {},
+	"ddcloud-docker-port":                 {},
+	"ddcloud-create-docker-firewall-rule": {},
+	"ddcloud-client-public-ip":            {},
+	"ddcloud-restart-mode":                {AllowedValues: []string{RestartModeReboot, RestartModeStopStart}},
+	"ddcloud-stop-grace-period":           {},
+	"ddcloud-existing-server":             {},
+	"ddcloud-delete-adopted-server":       {},
+	"ddcloud-harden-adopted-server":       {},
+	"ddcloud-dry-run":                     {},
+	"ddcloud-dry-run-format":              {AllowedValues: []string{DryRunFormatText, DryRunFormatJSON}},
+	"ddcloud-use-private-ip":              {},
+	"ddcloud-memorygb":                    {},
+	"ddcloud-cpucount":                    {},
+	"ddcloud-corespersocket":              {},
+}
+
+// The description of a create flag (as reported in the flag schema).
+type flagSchema struct {
+	// The flag name (without the leading "--").
+	Name string `json:"name"`
+
+	// The flag type ("string", "int", "bool", or "string-slice").
+	Type string `json:"type"`
+
+	// The flag's default value.
+	Default interface{} `json:"default"`
+
+	// The environment variable (if any) from which the flag's value can be read.
+	EnvVar string `json:"env_var,omitempty"`
+
+	// The flag's help text.
+	Usage string `json:"usage"`
+
+	// Must a value be supplied for the flag?
+	Required bool `json:"required"`
+
+	// Is the flag's value a secret?
+	Secret bool `json:"secret"`
+
+	// The values (if restricted) that the flag accepts.
+	AllowedValues []string `json:"allowed_values,omitempty"`
+}
+
+// Describe the specified create flags (using createFlagMetadata).
+func describeFlags(flags []mcnflag.Flag) ([]flagSchema, error) {
+	var missingMetadata []string
+
+	schema := make([]flagSchema, 0, len(flags))
+	flagNames := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		var flagDescription flagSchema
+		switch typedFlag := flag.(type) {
+		case mcnflag.StringFlag:
+			flagDescription = flagSchema{Name: typedFlag.Name, Type: flagTypeString, Default: typedFlag.Value, EnvVar: typedFlag.EnvVar, Usage: typedFlag.Usage}
+		case mcnflag.IntFlag:
+			flagDescription = flagSchema{Name: typedFlag.Name, Type: flagTypeInt, Default: typedFlag.Value, EnvVar: typedFlag.EnvVar, Usage: typedFlag.Usage}
+		case mcnflag.BoolFlag:
+			flagDescription = flagSchema{Name: typedFlag.Name, Type: flagTypeBool, Default: false, EnvVar: typedFlag.EnvVar, Usage: typedFlag.Usage}
+		case mcnflag.StringSliceFlag:
+			defaultValue := typedFlag.Value
+			if defaultValue == nil {
+				defaultValue = []string{}
+			}
+			flagDescription = flagSchema{Name: typedFlag.Name, Type: flagTypeStringSlice, Default: defaultValue, EnvVar: typedFlag.EnvVar, Usage: typedFlag.Usage}
+		default:
+			return nil, fmt.Errorf("Flag '%s' has unsupported type %T", flag.String(), flag)
+		}
+		flagNames[flagDescription.Name] = true
+
+		metadata, ok := createFlagMetadata[flagDescription.Name]
+		if !ok {
+			missingMetadata = append(missingMetadata, flagDescription.Name)
+
+			continue
+		}
+		flagDescription.Required = metadata.Required
+		flagDescription.Secret = metadata.Secret
+		flagDescription.AllowedValues = metadata.AllowedValues
+
+		schema = append(schema, flagDescription)
+	}
+	if len(missingMetadata) != 0 {
+		return nil, fmt.Errorf("No metadata has been defined for flags: %s", strings.Join(missingMetadata, ", "))
+	}
+
+	var unknownFlags []string
+	for flagName := range createFlagMetadata {
+		if !flagNames[flagName] {
+			unknownFlags = append(unknownFlags, flagName)
+		}
+	}
+	if len(unknownFlags) != 0 {
+		sort.Strings(unknownFlags)
+
+		return nil, fmt.Errorf("Metadata has been defined for unknown flags: %s", strings.Join(unknownFlags, ", "))
+	}
+
+	return schema, nil
+}
+
+// Print the schema for the driver's create flags as JSON.
+func (driver *Driver) printFlagSchema(writer io.Writer) error {
+	schema, err := describeFlags(driver.GetCreateFlags())
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(schema)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+func TestCreateFlagsHaveMetadata(t *testing.T) {
+	driver := &Driver{}
+	for _, flag := range driver.GetCreateFlags() {
+		if _, ok := createFlagMetadata[flag.String()]; !ok {
+			t.Errorf("Create flag '%s' has no entry in createFlagMetadata", flag.String())
+		}
+	}
+}
+
+func TestPrintFlagSchemaPreservesFlagDetails(t *testing.T) {
+	driver := &Driver{}
+
+	output := &bytes.Buffer{}
+	err := driver.printFlagSchema(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema []map[string]interface{}
+	err = json.Unmarshal(output.Bytes(), &schema)
+	if err != nil {
+		t.Fatalf("Flag schema is not valid JSON: %s", err)
+	}
+
+	createFlags := driver.GetCreateFlags()
+	if len(schema) != len(createFlags) {
+		t.Fatalf("Flag schema describes %d flags (expected %d)", len(schema), len(createFlags))
+	}
+
+	for index, flag := range createFlags {
+		flagDescription := schema[index]
+		if flagDescription["name"] != flag.String() {
+			t.Fatalf("Flag %d is named '%v' in the schema (expected '%s')", index, flagDescription["name"], flag.String())
+		}
+
+		var (
+			expectedDefault interface{}
+			expectedEnvVar  string
+		)
+		switch typedFlag := flag.(type) {
+		case mcnflag.StringFlag:
+			expectedDefault, expectedEnvVar = typedFlag.Value, typedFlag.EnvVar
+		case mcnflag.IntFlag:
+			expectedDefault, expectedEnvVar = float64(typedFlag.Value), typedFlag.EnvVar // JSON numbers are decoded as float64.
+		case mcnflag.BoolFlag:
+			expectedDefault, expectedEnvVar = false, typedFlag.EnvVar
+		case mcnflag.StringSliceFlag:
+			values := make([]interface{}, len(typedFlag.Value))
+			for valueIndex, value := range typedFlag.Value {
+				values[valueIndex] = value
+			}
+			expectedDefault, expectedEnvVar = values, typedFlag.EnvVar
+		default:
+			t.Fatalf("Flag '%s' has unexpected type %T", flag.String(), flag)
+		}
+
+		if !reflect.DeepEqual(flagDescription["default"], expectedDefault) {
+			t.Errorf("Flag '%s' has default %#v in the schema (expected %#v)", flag.String(), flagDescription["default"], expectedDefault)
+		}
+
+		envVar, _ := flagDescription["env_var"].(string)
+		if envVar != expectedEnvVar {
+			t.Errorf("Flag '%s' has environment variable '%s' in the schema (expected '%s')", flag.String(), envVar, expectedEnvVar)
+		}
+	}
+}
+
+func TestFlagSchemaVLANIsConditionallyRequired(t *testing.T) {
+	schema, err := describeFlags((&Driver{}).GetCreateFlags())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, flagDescription := range schema {
+		if flagDescription.Name != "ddcloud-vlan" {
+			continue
+		}
+
+		if flagDescription.Required {
+			t.Fatal("ddcloud-vlan is marked as required (it is not needed with --ddcloud-private-ipv4 or --ddcloud-existing-server)")
+		}
+		if !strings.Contains(flagDescription.Usage, "--ddcloud-private-ipv4") || !strings.Contains(flagDescription.Usage, "--ddcloud-existing-server") {
+			t.Fatalf("ddcloud-vlan usage does not describe when it is required: '%s'", flagDescription.Usage)
+		}
+
+		return
+	}
+
+	t.Fatal("ddcloud-vlan not found in flag schema")
+}
+
+func TestDescribeFlagsRejectsMissingAndUnknownMetadata(t *testing.T) {
+	flags := append((&Driver{}).GetCreateFlags(), mcnflag.StringFlag{Name: "ddcloud-undocumented"})
+	_, err := describeFlags(flags)
+	if err == nil || !strings.Contains(err.Error(), "ddcloud-undocumented") {
+		t.Fatalf("Expected missing metadata error for 'ddcloud-undocumented' (got %v)", err)
+	}
+
+	_, err = describeFlags([]mcnflag.Flag{
+		mcnflag.StringFlag{Name: "ddcloud-vlan"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown flags") {
+		t.Fatalf("Expected unknown flags error (got %v)", err)
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/DimensionDataResearch/docker-machine-driver-ddcloud
+
+go 1.25.0
+
+require (
+	github.com/DimensionDataResearch/go-dd-cloud-compute v1.13.0
+	github.com/docker/machine v0.16.2
+	golang.org/x/crypto v0.54.0
+	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.45.0
+)
+
+require (
+	github.com/Azure/go-ansiterm v0.0.0-20250102033503-faa5f7b0171c // indirect
+	github.com/Sirupsen/logrus v1.0.6 // indirect
+	github.com/docker/docker v1.13.1 // indirect
+	github.com/docker/go-units v0.5.0 // indirect
+	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/samalba/dockerclient v0.0.0-20160531175551-a30362618471 // indirect
+	github.com/sirupsen/logrus v1.10.2 // indirect
+	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
+	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
+)
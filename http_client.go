@@ -0,0 +1,217 @@
+package main
+
+/*
+ * HTTP client configuration
+ * -------------------------
+ *
+ * Proxy and TLS settings for outbound HTTPS requests (the CloudControl API and public IP address detection).
+ *
+ * Note that, because the CloudControl API client cannot be given its own HTTP client, the first CloudControl client created by the driver
+ * replaces http.DefaultTransport (for the whole process) with a cloudControlTransport; see registerCloudControlTransport.
+ */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// The timeout for outbound HTTP requests (other than those made by the CloudControl API client, which has its own retry logic).
+const httpRequestTimeout = 30 * time.Second
+
+// The original default HTTP transport (the basis for the driver's transports, and used for requests that are not made to a registered CloudControl end-point).
+var defaultHTTPTransport = http.DefaultTransport.(*http.Transport)
+
+// Proxy and TLS settings for outbound HTTPS requests.
+type httpClientSettings struct {
+	// The URL of the HTTP proxy (if any) to use; credentials, if required, can be included in the URL.
+	//
+	// If not specified, the standard HTTP_PROXY / HTTPS_PROXY / NO_PROXY environment variables are used.
+	ProxyURL string
+
+	// The path to a PEM file containing additional CA certificates to trust.
+	CACertFile string
+
+	// The path to a PEM file containing a client certificate to present.
+	ClientCertFile string
+
+	// The path to a PEM file containing the client certificate's private key.
+	ClientKeyFile string
+
+	// Skip verification of server certificates (insecure; only use with lab end-points).
+	InsecureSkipVerify bool
+}
+
+// Create an HTTP transport using the specified settings.
+func newHTTPTransport(settings httpClientSettings) (*http.Transport, error) {
+	transport := defaultHTTPTransport.Clone()
+
+	if settings.ProxyURL != "" {
+		proxyURL, err := url.Parse(settings.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL: %s", err.Error())
+		}
+		if proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("Invalid proxy URL '%s' (expected 'scheme://[user:password@]host:port')", proxyURL.Redacted())
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if settings.CACertFile != "" {
+		certificateData, err := ioutil.ReadFile(settings.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(certificateData) {
+			return nil, fmt.Errorf("No valid CA certificates found in '%s'", settings.CACertFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if settings.ClientCertFile != "" || settings.ClientKeyFile != "" {
+		if settings.ClientCertFile == "" || settings.ClientKeyFile == "" {
+			return nil, errors.New("Both a client certificate and a client key must be specified")
+		}
+
+		clientCertificate, err := tls.LoadX509KeyPair(settings.ClientCertFile, settings.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate '%s': %s", settings.ClientCertFile, err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCertificate}
+	}
+
+	if settings.InsecureSkipVerify {
+		log.Warnf("TLS certificate verification is disabled; this is insecure, and should only be used with lab end-points.")
+
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// Get the HTTP client settings configured for the driver.
+func (driver *Driver) getHTTPClientSettings() httpClientSettings {
+	return httpClientSettings{
+		ProxyURL:           driver.CloudControlProxy,
+		CACertFile:         driver.CloudControlCACert,
+		ClientCertFile:     driver.CloudControlClientCert,
+		ClientKeyFile:      driver.CloudControlClientKey,
+		InsecureSkipVerify: driver.CloudControlInsecureSkipVerify,
+	}
+}
+
+// Get the HTTP transport used by the driver for outbound HTTPS requests.
+func (driver *Driver) getHTTPTransport() (*http.Transport, error) {
+	if driver.httpTransport != nil {
+		return driver.httpTransport, nil
+	}
+
+	transport, err := newHTTPTransport(
+		driver.getHTTPClientSettings(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	driver.httpTransport = transport
+
+	return transport, nil
+}
+
+// Get an HTTP client that uses the driver's proxy and TLS settings.
+func (driver *Driver) getHTTPClient() (*http.Client, error) {
+	transport, err := driver.getHTTPTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   httpRequestTimeout,
+	}, nil
+}
+
+// Routes requests made by CloudControl API clients to the transport configured for their end-point and user.
+//
+// The CloudControl API client does not allow its HTTP client to be configured (it always uses http.DefaultTransport),
+// so a single cloudControlTransport is installed as the default transport; requests that do not match a registered end-point and user
+// (e.g. those made by Docker Machine itself) continue to use the original default transport.
+type cloudControlTransport struct {
+	stateLock  sync.RWMutex
+	transports map[string]http.RoundTripper
+	fallback   http.RoundTripper
+}
+
+var (
+	cloudControlTransports = &cloudControlTransport{
+		transports: make(map[string]http.RoundTripper),
+		fallback:   defaultHTTPTransport,
+	}
+	installCloudControlTransport sync.Once
+)
+
+// Use the specified transport for requests made by the CloudControl API client to the specified end-point (as the specified user).
+//
+// The first call installs cloudControlTransports as http.DefaultTransport for the whole process (it is never uninstalled).
+func registerCloudControlTransport(baseAddress string, user string, transport http.RoundTripper) error {
+	baseURL, err := url.Parse(baseAddress)
+	if err != nil {
+		return fmt.Errorf("Invalid CloudControl end-point URI '%s': %s", baseAddress, err.Error())
+	}
+	if baseURL.Host == "" {
+		return fmt.Errorf("Invalid CloudControl end-point URI '%s' (expected 'scheme://host[:port]')", baseAddress)
+	}
+
+	cloudControlTransports.register(baseURL.Host, user, transport)
+	installCloudControlTransport.Do(func() {
+		http.DefaultTransport = cloudControlTransports
+	})
+
+	return nil
+}
+
+// Get the key used to look up the transport for the specified host and user.
+func getCloudControlTransportKey(host string, user string) string {
+	return strings.ToLower(host) + "/" + user
+}
+
+// Register the transport for the specified host and user.
+func (routingTransport *cloudControlTransport) register(host string, user string, transport http.RoundTripper) {
+	routingTransport.stateLock.Lock()
+	defer routingTransport.stateLock.Unlock()
+
+	routingTransport.transports[getCloudControlTransportKey(host, user)] = transport
+}
+
+// RoundTrip executes a single HTTP transaction (using the transport registered for the request's host and user, if any).
+func (routingTransport *cloudControlTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	user, _, _ := request.BasicAuth()
+
+	routingTransport.stateLock.RLock()
+	transport, ok := routingTransport.transports[getCloudControlTransportKey(request.URL.Host, user)]
+	routingTransport.stateLock.RUnlock()
+
+	if !ok {
+		transport = routingTransport.fallback
+	}
+
+	return transport.RoundTrip(request)
+}
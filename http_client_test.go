@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Write a PEM block to a file in the specified directory.
+func writeTestPEMFile(t *testing.T, directory string, fileName string, blockType string, data []byte) string {
+	t.Helper()
+
+	fileName = filepath.Join(directory, fileName)
+	err := ioutil.WriteFile(fileName,
+		pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+// Generate a certificate (and its private key), signed by the specified parent (or self-signed, if parent is nil).
+func generateTestCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	certificateData, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate, key
+}
+
+// Perform a GET request using the specified transport.
+func getWithTransport(transport http.RoundTripper, url string) (*http.Response, error) {
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	return response, nil
+}
+
+func TestNewHTTPTransportTrustsCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	transport, err := newHTTPTransport(httpClientSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = getWithTransport(transport, server.URL)
+	if err == nil {
+		t.Fatal("Request to server with untrusted certificate succeeded (expected certificate verification to fail)")
+	}
+
+	caCertFile := writeTestPEMFile(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	transport, err = newHTTPTransport(httpClientSettings{
+		CACertFile: caCertFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := getWithTransport(transport, server.URL)
+	if err != nil {
+		t.Fatalf("Request to server with custom CA failed: %s", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", response.StatusCode)
+	}
+}
+
+func TestNewHTTPTransportRejectsInvalidCACertFile(t *testing.T) {
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := ioutil.WriteFile(caCertFile, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newHTTPTransport(httpClientSettings{
+		CACertFile: caCertFile,
+	})
+	if err == nil || !strings.Contains(err.Error(), "No valid CA certificates") {
+		t.Fatalf("Expected invalid CA certificate error (got %v)", err)
+	}
+}
+
+func TestNewHTTPTransportPresentsClientCertificate(t *testing.T) {
+	caCertificate, caKey := generateTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	clientCertificate, clientKey := generateTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCertificate, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCertificate)
+
+	var clientName string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		clientName = request.TLS.PeerCertificates[0].Subject.CommonName
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	directory := t.TempDir()
+	caCertFile := writeTestPEMFile(t, directory, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	clientCertFile := writeTestPEMFile(t, directory, "client.pem", "CERTIFICATE", clientCertificate.Raw)
+	clientKeyData, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKeyFile := writeTestPEMFile(t, directory, "client-key.pem", "EC PRIVATE KEY", clientKeyData)
+
+	transport, err := newHTTPTransport(httpClientSettings{
+		CACertFile: caCertFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = getWithTransport(transport, server.URL)
+	if err == nil {
+		t.Fatal("Request without client certificate succeeded (expected server to require one)")
+	}
+
+	transport, err = newHTTPTransport(httpClientSettings{
+		CACertFile:     caCertFile,
+		ClientCertFile: clientCertFile,
+		ClientKeyFile:  clientKeyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := getWithTransport(transport, server.URL)
+	if err != nil {
+		t.Fatalf("Request with client certificate failed: %s", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", response.StatusCode)
+	}
+	if clientName != "Test client" {
+		t.Fatalf("Server received client certificate for '%s' (expected 'Test client')", clientName)
+	}
+
+	_, err = newHTTPTransport(httpClientSettings{
+		ClientCertFile: clientCertFile,
+	})
+	if err == nil {
+		t.Fatal("Client certificate without key was accepted")
+	}
+}
+
+func TestNewHTTPTransportUsesProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		proxiedURL = request.URL.String()
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	transport, err := newHTTPTransport(httpClientSettings{
+		ProxyURL: proxy.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := getWithTransport(transport, "http://cloudcontrol.example.com/caas/2.4/")
+	if err != nil {
+		t.Fatalf("Request via proxy failed: %s", err)
+	}
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status code %d", response.StatusCode)
+	}
+	if proxiedURL != "http://cloudcontrol.example.com/caas/2.4/" {
+		t.Fatalf("Proxy received request for '%s'", proxiedURL)
+	}
+
+	_, err = newHTTPTransport(httpClientSettings{
+		ProxyURL: "proxy.example.com:3128",
+	})
+	if err == nil {
+		t.Fatal("Proxy URL without scheme was accepted")
+	}
+}
+
+func TestNewHTTPTransportWarnsWhenSkippingVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	output := &bytes.Buffer{}
+	log.SetOutWriter(output)
+	defer log.SetOutWriter(os.Stdout)
+
+	transport, err := newHTTPTransport(httpClientSettings{
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "TLS certificate verification is disabled") {
+		t.Fatalf("Expected warning about disabled certificate verification (got '%s')", output.String())
+	}
+
+	_, err = getWithTransport(transport, server.URL)
+	if err != nil {
+		t.Fatalf("Request with verification disabled failed: %s", err)
+	}
+
+	output.Reset()
+	_, err = newHTTPTransport(httpClientSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Len() != 0 {
+		t.Fatalf("Unexpected output '%s' with verification enabled", output.String())
+	}
+}
+
+func TestCloudControlTransportRoutesByEndPointAndUser(t *testing.T) {
+	var routedTo []string
+	newRecordingTransport := func(name string) http.RoundTripper {
+		return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			routedTo = append(routedTo, name)
+
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: request}, nil
+		})
+	}
+
+	routingTransport := &cloudControlTransport{
+		transports: make(map[string]http.RoundTripper),
+		fallback:   newRecordingTransport("default"),
+	}
+	routingTransport.register("api-au.dimensiondata.com", "user1", newRecordingTransport("driver"))
+
+	requests := []struct {
+		URL  string
+		User string
+	}{
+		{"https://api-au.dimensiondata.com/caas/2.4/", "user1"},
+		{"https://API-AU.dimensiondata.com/caas/2.4/", "user1"},
+		{"https://api-au.dimensiondata.com/caas/2.4/", "user2"},
+		{"https://api-na.dimensiondata.com/caas/2.4/", "user1"},
+		{"https://registry.example.com/v2/", ""},
+	}
+	for _, testRequest := range requests {
+		request, err := http.NewRequest(http.MethodGet, testRequest.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testRequest.User != "" {
+			request.SetBasicAuth(testRequest.User, "password")
+		}
+
+		_, err = routingTransport.RoundTrip(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := "driver,driver,default,default,default"
+	if strings.Join(routedTo, ",") != expected {
+		t.Fatalf("Requests routed to %s (expected %s)", strings.Join(routedTo, ","), expected)
+	}
+}
+
+func TestRegisterCloudControlTransportRejectsInvalidEndPoint(t *testing.T) {
+	err := registerCloudControlTransport("api-au.dimensiondata.com", "user1", http.DefaultTransport)
+	if err == nil {
+		t.Fatal("End-point URI without scheme was accepted")
+	}
+}
+
+// An http.RoundTripper implemented by a function.
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+// RoundTrip executes a single HTTP transaction.
+func (roundTripper roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return roundTripper(request)
+}
@@ -0,0 +1,374 @@
+package main
+
+/*
+ * Resource discovery
+ * ------------------
+ *
+ * "docker-machine-driver-ddcloud list <resource>" lists the CloudControl resources (data centres, network domains, VLANs, images, or public IPs)
+ * whose names can be passed to the driver's create flags.
+ *
+ * Connection and credentials are configured using the same --ddcloud-* flags (and environment variables) as "docker-machine create";
+ * use --ddcloud-mcp-endpoint to target a different CloudControl end-point (e.g. a fake API server for testing).
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Output formats for "list".
+const (
+	listOutputTable = "table"
+	listOutputJSON  = "json"
+)
+
+// A table of resources (one row per resource).
+type resourceTable struct {
+	// The column names (used as keys in JSON output).
+	Columns []string
+
+	// The resource rows (one value per column).
+	Rows [][]string
+}
+
+// Add a row to the table.
+func (table *resourceTable) add(values ...string) {
+	table.Rows = append(table.Rows, values)
+}
+
+// Write the table as aligned text.
+func (table *resourceTable) writeText(writer io.Writer) error {
+	if len(table.Rows) == 0 {
+		_, err := fmt.Fprintln(writer, "No resources found.")
+
+		return err
+	}
+
+	tableWriter := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	headings := make([]string, len(table.Columns))
+	for index, column := range table.Columns {
+		headings[index] = strings.ToUpper(strings.Replace(column, "_", " ", -1))
+	}
+	fmt.Fprintln(tableWriter, strings.Join(headings, "\t"))
+	for _, row := range table.Rows {
+		fmt.Fprintln(tableWriter, strings.Join(row, "\t"))
+	}
+
+	return tableWriter.Flush()
+}
+
+// Write the table as a JSON array of objects (keyed by column name).
+func (table *resourceTable) writeJSON(writer io.Writer) error {
+	resources := make([]map[string]string, len(table.Rows))
+	for rowIndex, row := range table.Rows {
+		resource := make(map[string]string, len(table.Columns))
+		for columnIndex, column := range table.Columns {
+			resource[column] = row[columnIndex]
+		}
+		resources[rowIndex] = resource
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(resources)
+}
+
+// The resources that can be listed (and the functions that list them).
+var listableResources = map[string]func(driver *Driver) (*resourceTable, error){
+	"datacenters":    (*Driver).listDataCentersTable,
+	"networkdomains": (*Driver).listNetworkDomainsTable,
+	"vlans":          (*Driver).listVLANsTable,
+	"images":         (*Driver).listImagesTable,
+	"public-ips":     (*Driver).listPublicIPsTable,
+}
+
+// Get the names of the resources that can be listed.
+func getListableResourceNames() []string {
+	resourceNames := make([]string, 0, len(listableResources))
+	for resourceName := range listableResources {
+		resourceNames = append(resourceNames, resourceName)
+	}
+	sort.Strings(resourceNames)
+
+	return resourceNames
+}
+
+// Run "list <resource>" with the specified command-line arguments.
+func runList(resourceName string, args []string, writer io.Writer) error {
+	listResources, ok := listableResources[resourceName]
+	if !ok {
+		return fmt.Errorf("Cannot list '%s' (expected one of: %s)", resourceName, strings.Join(getListableResourceNames(), ", "))
+	}
+
+	// Progress messages (e.g. when resolving the network domain) must not be mixed with the output.
+	log.SetOutWriter(os.Stderr)
+
+	driver := &Driver{}
+
+	var outputFormat string
+	flags := flag.NewFlagSet("list "+resourceName, flag.ContinueOnError)
+	flags.StringVar(&outputFormat, "output", listOutputTable, "The output format ('table' or 'json')")
+	registerDriverFlags(flags, driver.GetCreateFlags())
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("Unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	if outputFormat != listOutputTable && outputFormat != listOutputJSON {
+		return fmt.Errorf("Invalid output format '%s' (expected '%s' or '%s')", outputFormat, listOutputTable, listOutputJSON)
+	}
+
+	options := &commandLineDriverOptions{flags}
+	err = driver.setCloudControlConfigFromFlags(options)
+	if err != nil {
+		return err
+	}
+	driver.NetworkDomainName = options.String("ddcloud-networkdomain")
+	driver.DataCenterID = options.String("ddcloud-datacenter")
+
+	table, err := listResources(driver)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == listOutputJSON {
+		return table.writeJSON(writer)
+	}
+
+	return table.writeText(writer)
+}
+
+// List the data centres in the CloudControl region.
+func (driver *Driver) listDataCentersTable() (*resourceTable, error) {
+	dataCenters, err := driver.listDataCenters()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &resourceTable{
+		Columns: []string{"id", "name", "city", "country"},
+	}
+	for _, dataCenter := range dataCenters {
+		table.add(dataCenter.ID, dataCenter.DisplayName, dataCenter.City, dataCenter.Country)
+	}
+
+	return table, nil
+}
+
+// List the network domains in the CloudControl region (or only those in the target data centre, if specified).
+func (driver *Driver) listNetworkDomainsTable() (*resourceTable, error) {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &resourceTable{
+		Columns: []string{"id", "name", "datacenter", "type", "status"},
+	}
+
+	page := compute.DefaultPaging()
+	for {
+		var networkDomains *compute.NetworkDomains
+		networkDomains, err = client.ListNetworkDomains(page)
+		if err != nil {
+			return nil, err
+		}
+		if networkDomains.IsEmpty() {
+			break // We're done
+		}
+
+		for _, networkDomain := range networkDomains.Domains {
+			if driver.DataCenterID != "" && !strings.EqualFold(networkDomain.DatacenterID, driver.DataCenterID) {
+				continue
+			}
+
+			table.add(networkDomain.ID, networkDomain.Name, networkDomain.DatacenterID, networkDomain.Type, networkDomain.State)
+		}
+
+		page.Next()
+	}
+
+	return table, nil
+}
+
+// List the VLANs in the target network domain.
+func (driver *Driver) listVLANsTable() (*resourceTable, error) {
+	err := driver.resolveListNetworkDomain()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &resourceTable{
+		Columns: []string{"id", "name", "ipv4_range", "status"},
+	}
+
+	page := compute.DefaultPaging()
+	for {
+		var vlans *compute.VLANs
+		vlans, err = client.ListVLANs(driver.NetworkDomainID, page)
+		if err != nil {
+			return nil, err
+		}
+		if vlans.IsEmpty() {
+			break // We're done
+		}
+
+		for _, vlan := range vlans.VLANs {
+			table.add(vlan.ID, vlan.Name,
+				fmt.Sprintf("%s/%d", vlan.IPv4Range.BaseAddress, vlan.IPv4Range.PrefixSize),
+				vlan.State,
+			)
+		}
+
+		page.Next()
+	}
+
+	return table, nil
+}
+
+// List the OS and customer images in the target data centre.
+func (driver *Driver) listImagesTable() (*resourceTable, error) {
+	if driver.DataCenterID == "" && driver.NetworkDomainName == "" {
+		return nil, errors.New("Listing images requires --ddcloud-datacenter (or --ddcloud-networkdomain)")
+	}
+
+	err := driver.resolveDataCenter()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	table := &resourceTable{
+		Columns: []string{"id", "name", "type", "os"},
+	}
+
+	page := compute.DefaultPaging()
+	for {
+		var images *compute.OSImages
+		images, err = client.ListOSImagesInDatacenter(driver.DataCenterID, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(images.Images) == 0 {
+			break // We're done
+		}
+
+		for _, image := range images.Images {
+			table.add(image.ID, image.Name, compute.ImageTypeName(compute.ImageTypeOS), image.Guest.OperatingSystem.ID)
+		}
+
+		page.Next()
+	}
+
+	page = compute.DefaultPaging()
+	for {
+		var images *compute.CustomerImages
+		images, err = client.ListCustomerImagesInDatacenter(driver.DataCenterID, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(images.Images) == 0 {
+			break // We're done
+		}
+
+		for _, image := range images.Images {
+			table.add(image.ID, image.Name, compute.ImageTypeName(compute.ImageTypeCustomer), image.Guest.OperatingSystem.ID)
+		}
+
+		page.Next()
+	}
+
+	return table, nil
+}
+
+// List the public IP blocks (and their available addresses) in the target network domain.
+func (driver *Driver) listPublicIPsTable() (*resourceTable, error) {
+	err := driver.resolveListNetworkDomain()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	availableIPs, err := client.GetAvailablePublicIPAddresses(driver.NetworkDomainID)
+	if err != nil {
+		return nil, err
+	}
+
+	availableIPsByBlock := make(map[string][]string)
+	for availableIP, blockID := range availableIPs {
+		availableIPsByBlock[blockID] = append(availableIPsByBlock[blockID], availableIP)
+	}
+
+	table := &resourceTable{
+		Columns: []string{"block_id", "base_ip", "size", "available", "available_ips"},
+	}
+
+	page := compute.DefaultPaging()
+	for {
+		var blocks *compute.PublicIPBlocks
+		blocks, err = client.ListPublicIPBlocks(driver.NetworkDomainID, page)
+		if err != nil {
+			return nil, err
+		}
+		if blocks.IsEmpty() {
+			break // We're done
+		}
+
+		for _, block := range blocks.Blocks {
+			blockAvailableIPs := availableIPsByBlock[block.ID]
+			sort.Strings(blockAvailableIPs)
+
+			table.add(block.ID, block.BaseIP,
+				strconv.Itoa(block.Size),
+				strconv.Itoa(len(blockAvailableIPs)),
+				strings.Join(blockAvailableIPs, ","),
+			)
+		}
+
+		page.Next()
+	}
+
+	return table, nil
+}
+
+// Resolve the target data centre and network domain for "list".
+func (driver *Driver) resolveListNetworkDomain() error {
+	if driver.NetworkDomainName == "" {
+		return errors.New("Network domain (--ddcloud-networkdomain) has not been specified")
+	}
+
+	err := driver.resolveDataCenter()
+	if err != nil {
+		return err
+	}
+
+	return driver.resolveNetworkDomain()
+}
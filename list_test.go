@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Create a fake CloudControl API with a data centre and network domain to list resources from.
+func newListTestCloudControl(t *testing.T) *fakeCloudControl {
+	t.Helper()
+
+	fake := newFakeCloudControl(t)
+	fake.Datacenters = []compute.Datacenter{
+		{ID: "AU9", DisplayName: "Sydney 2", City: "Sydney", Country: "Australia"},
+		{ID: "AU10", DisplayName: "Melbourne", City: "Melbourne", Country: "Australia"},
+	}
+	fake.NetworkDomains = []compute.NetworkDomain{
+		{ID: "network-domain-1", Name: "Test", DatacenterID: "AU9", Type: "ESSENTIALS", State: compute.ResourceStatusNormal},
+	}
+
+	return fake
+}
+
+// Run "list <resource>" against the fake CloudControl API.
+func runListAgainst(fake *fakeCloudControl, resourceName string, args ...string) (string, error) {
+	defer log.SetOutWriter(os.Stdout) // runList redirects progress messages to stderr.
+
+	output := &bytes.Buffer{}
+	err := runList(resourceName, append([]string{
+		"--ddcloud-mcp-endpoint", fake.URL(),
+		"--ddcloud-mcp-user", "test-user",
+		"--ddcloud-mcp-password", "test-password",
+	}, args...), output)
+
+	return output.String(), err
+}
+
+func TestListDataCenters(t *testing.T) {
+	fake := newListTestCloudControl(t)
+
+	output, err := runListAgainst(fake, "datacenters")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID    NAME       CITY       COUNTRY\n" +
+		"AU9   Sydney 2   Sydney     Australia\n" +
+		"AU10  Melbourne  Melbourne  Australia\n"
+	if output != expected {
+		t.Fatalf("Unexpected output:\n%s\nExpected:\n%s", output, expected)
+	}
+}
+
+func TestListNetworkDomainsReadsAllPages(t *testing.T) {
+	fake := newListTestCloudControl(t)
+
+	// More than one page (the default page size is 50).
+	for index := 2; index <= 60; index++ {
+		dataCenterID := "AU9"
+		if index%2 == 0 {
+			dataCenterID = "AU10"
+		}
+
+		fake.NetworkDomains = append(fake.NetworkDomains, compute.NetworkDomain{
+			ID:           fmt.Sprintf("network-domain-%d", index),
+			Name:         fmt.Sprintf("Test %d", index),
+			DatacenterID: dataCenterID,
+			Type:         "ADVANCED",
+			State:        compute.ResourceStatusNormal,
+		})
+	}
+
+	output, err := runListAgainst(fake, "networkdomains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 61 {
+		t.Fatalf("Listed %d lines (expected heading and 60 network domains):\n%s", len(lines), output)
+	}
+	if !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[0], "DATACENTER") {
+		t.Fatalf("Unexpected heading '%s'", lines[0])
+	}
+	if !strings.HasPrefix(lines[60], "network-domain-60 ") {
+		t.Fatalf("Unexpected last line '%s'", lines[60])
+	}
+
+	output, err = runListAgainst(fake, "networkdomains", "--ddcloud-datacenter", "au9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 31 {
+		t.Fatalf("Listed %d lines (expected heading and 30 network domains in AU9):\n%s", len(lines), output)
+	}
+	for _, line := range lines[1:] {
+		if !strings.Contains(line, " AU9 ") {
+			t.Fatalf("Listed network domain outside AU9: '%s'", line)
+		}
+	}
+}
+
+func TestListVLANs(t *testing.T) {
+	fake := newListTestCloudControl(t)
+	fake.VLANs = []compute.VLAN{
+		{
+			ID:            "vlan-1",
+			Name:          "Primary",
+			NetworkDomain: compute.EntityReference{ID: "network-domain-1"},
+			IPv4Range:     compute.IPv4Range{BaseAddress: "10.0.0.0", PrefixSize: 24},
+			State:         compute.ResourceStatusNormal,
+		},
+	}
+
+	output, err := runListAgainst(fake, "vlans", "--ddcloud-networkdomain", "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID      NAME     IPV4 RANGE   STATUS\n" +
+		"vlan-1  Primary  10.0.0.0/24  NORMAL\n"
+	if output != expected {
+		t.Fatalf("Unexpected output:\n%s\nExpected:\n%s", output, expected)
+	}
+}
+
+func TestListVLANsWhenNoneExist(t *testing.T) {
+	fake := newListTestCloudControl(t)
+
+	output, err := runListAgainst(fake, "vlans", "--ddcloud-networkdomain", "Test", "--ddcloud-datacenter", "AU9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "No resources found.\n" {
+		t.Fatalf("Unexpected output '%s'", output)
+	}
+}
+
+func TestListImages(t *testing.T) {
+	fake := newListTestCloudControl(t)
+	osImage := compute.OSImage{ID: "os-image-1", Name: "Ubuntu 16.04 64-bit", DataCenterID: "AU9"}
+	osImage.Guest.OperatingSystem.ID = "UBUNTU1664"
+	customerImage := compute.CustomerImage{ID: "customer-image-1", Name: "Docker Host", DataCenterID: "AU9"}
+	customerImage.Guest.OperatingSystem.ID = "UBUNTU1664"
+	otherImage := compute.OSImage{ID: "os-image-2", Name: "CentOS 7 64-bit", DataCenterID: "AU10"}
+	fake.OSImages = []compute.OSImage{osImage, otherImage}
+	fake.CustomerImages = []compute.CustomerImage{customerImage}
+
+	output, err := runListAgainst(fake, "images", "--ddcloud-datacenter", "AU9")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID                NAME                 TYPE      OS\n" +
+		"os-image-1        Ubuntu 16.04 64-bit  OS        UBUNTU1664\n" +
+		"customer-image-1  Docker Host          Customer  UBUNTU1664\n"
+	if output != expected {
+		t.Fatalf("Unexpected output:\n%s\nExpected:\n%s", output, expected)
+	}
+}
+
+func TestListPublicIPsAsJSON(t *testing.T) {
+	fake := newListTestCloudControl(t)
+	fake.PublicIPBlocks = []compute.PublicIPBlock{
+		{ID: "public-ip-block-1", NetworkDomainID: "network-domain-1", BaseIP: "203.0.113.1", Size: 2, State: compute.ResourceStatusNormal},
+	}
+	fake.NATRules = []compute.NATRule{
+		{ID: "nat-rule-1", NetworkDomainID: "network-domain-1", InternalIPAddress: "10.0.0.10", ExternalIPAddress: "203.0.113.1"},
+	}
+
+	output, err := runListAgainst(fake, "public-ips", "--ddcloud-networkdomain", "Test", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var publicIPs []map[string]string
+	err = json.Unmarshal([]byte(output), &publicIPs)
+	if err != nil {
+		t.Fatalf("Output is not valid JSON (%s):\n%s", err, output)
+	}
+
+	expected := []map[string]string{
+		{
+			"block_id":      "public-ip-block-1",
+			"base_ip":       "203.0.113.1",
+			"size":          "2",
+			"available":     "1",
+			"available_ips": "203.0.113.2",
+		},
+	}
+	if !reflect.DeepEqual(publicIPs, expected) {
+		t.Fatalf("Unexpected output %#v (expected %#v)", publicIPs, expected)
+	}
+}
+
+func TestListRejectsInvalidArguments(t *testing.T) {
+	fake := newListTestCloudControl(t)
+
+	_, err := runListAgainst(fake, "servers")
+	if err == nil || !strings.Contains(err.Error(), "datacenters, images, networkdomains, public-ips, vlans") {
+		t.Fatalf("Expected unknown resource error (got %v)", err)
+	}
+
+	_, err = runListAgainst(fake, "datacenters", "--output", "yaml")
+	if err == nil || !strings.Contains(err.Error(), "Invalid output format") {
+		t.Fatalf("Expected invalid output format error (got %v)", err)
+	}
+
+	_, err = runListAgainst(fake, "vlans")
+	if err == nil || !strings.Contains(err.Error(), "--ddcloud-networkdomain") {
+		t.Fatalf("Expected missing network domain error (got %v)", err)
+	}
+
+	_, err = runListAgainst(fake, "images")
+	if err == nil || !strings.Contains(err.Error(), "--ddcloud-datacenter") {
+		t.Fatalf("Expected missing data centre error (got %v)", err)
+	}
+}
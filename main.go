@@ -13,6 +13,9 @@ import (
 	"path"
 )
 
+// DriverVersion is the current version of the CloudControl driver for Docker Machine (set at build time; see Makefile).
+var DriverVersion = "dev"
+
 func main() {
 	if len(os.Args) == 2 && os.Args[1] == "--version" {
 		fmt.Printf("%s %s\n\n", path.Base(os.Args[0]), DriverVersion)
@@ -20,6 +23,66 @@ func main() {
 		return
 	}
 
+	if len(os.Args) == 2 && os.Args[1] == "--describe-flags" {
+		err := (&Driver{}).printFlagSchema(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) == 3 && os.Args[1] == "port-forward" {
+		err := runDockerPortForward(os.Args[2])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) == 3 && os.Args[1] == "timeline" {
+		err := printAuditTimeline(os.Args[2], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) >= 3 && os.Args[1] == "list" {
+		err := runList(os.Args[2], os.Args[3:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "plan" {
+		err := runPlan(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) >= 3 && os.Args[1] == "cluster" && os.Args[2] == "create" {
+		err := runClusterCreate(os.Args[3:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	plugin.RegisterDriver(
 		&Driver{BaseDriver: &drivers.BaseDriver{
 			SSHUser: "root",
@@ -0,0 +1,276 @@
+package main
+
+/*
+ * Encryption of secrets in the machine configuration
+ * --------------------------------------------------
+ *
+ * Docker Machine persists the driver's exported fields (in plain text) in each machine's config.json.
+ * If an encryption key is available, secrets (the CloudControl password and the SSH bootstrap password) are encrypted (AES-256-GCM) before being persisted,
+ * and only decrypted when they are needed.
+ *
+ * The key is obtained from (in order of preference):
+ *
+ * 1. MCP_CONFIG_KEY (base64-encoded 256-bit key)
+ * 2. MCP_CONFIG_KEY_FILE or --ddcloud-config-key-file (file containing a base64-encoded 256-bit key)
+ * 3. MCP_CONFIG_PASSPHRASE (a passphrase, from which a key is derived using scrypt)
+ *
+ * Secrets persisted by older versions of the driver (in plain text) are encrypted the next time they are used.
+ */
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Environment variables used to supply the configuration encryption key.
+const (
+	configKeyEnvVar        = "MCP_CONFIG_KEY"
+	configKeyFileEnvVar    = "MCP_CONFIG_KEY_FILE"
+	configPassphraseEnvVar = "MCP_CONFIG_PASSPHRASE"
+)
+
+// Prefixes that identify encrypted secrets (and how their keys are obtained).
+const (
+	encryptedSecretPrefix           = "enc:v1:"
+	encryptedSecretWithKeyPrefix    = encryptedSecretPrefix + "key:"
+	encryptedSecretWithScryptPrefix = encryptedSecretPrefix + "scrypt:"
+)
+
+// Encryption parameters.
+const (
+	secretKeySize  = 32
+	secretSaltSize = 16
+
+	// scrypt parameters (as recommended for interactive logins).
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// A source for the configuration encryption key.
+type secretKeySource struct {
+	// The raw key (if supplied directly).
+	Key []byte
+
+	// The passphrase from which the key is derived (if not supplied directly).
+	Passphrase string
+}
+
+// Get the source of the configuration encryption key (nil if none is configured).
+func (driver *Driver) getSecretKeySource() (*secretKeySource, error) {
+	encodedKey := strings.TrimSpace(os.Getenv(configKeyEnvVar))
+	if encodedKey != "" {
+		return decodeSecretKey(encodedKey, configKeyEnvVar)
+	}
+
+	keyFile := driver.ConfigKeyFile
+	if keyFile == "" {
+		keyFile = os.Getenv(configKeyFileEnvVar)
+	}
+	if keyFile != "" {
+		keyData, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read configuration encryption key file: %s", err.Error())
+		}
+
+		return decodeSecretKey(strings.TrimSpace(string(keyData)), keyFile)
+	}
+
+	passphrase := os.Getenv(configPassphraseEnvVar)
+	if passphrase != "" {
+		return &secretKeySource{Passphrase: passphrase}, nil
+	}
+
+	return nil, nil
+}
+
+// Decode a base64-encoded encryption key.
+func decodeSecretKey(encodedKey string, source string) (*secretKeySource, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid configuration encryption key in '%s' (expected base64-encoded key): %s", source, err.Error())
+	}
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("Invalid configuration encryption key in '%s' (expected %d bytes, but found %d)", source, secretKeySize, len(key))
+	}
+
+	return &secretKeySource{Key: key}, nil
+}
+
+// Determine whether a persisted secret is encrypted.
+func isEncryptedSecret(secret string) bool {
+	return strings.HasPrefix(secret, encryptedSecretPrefix)
+}
+
+// Encrypt a secret for persistence (if an encryption key is available).
+func (driver *Driver) encryptSecret(secret string) (string, error) {
+	if secret == "" || isEncryptedSecret(secret) {
+		return secret, nil
+	}
+
+	keySource, err := driver.getSecretKeySource()
+	if err != nil {
+		return "", err
+	}
+	if keySource == nil {
+		log.Debugf("No configuration encryption key available (set %s, %s, or %s); secrets will be persisted without encryption.",
+			configKeyEnvVar,
+			configKeyFileEnvVar,
+			configPassphraseEnvVar,
+		)
+
+		return secret, nil
+	}
+
+	salt := make([]byte, secretSaltSize)
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := encryptedSecretWithKeyPrefix
+	key := keySource.Key
+	if key == nil {
+		prefix = encryptedSecretWithScryptPrefix
+		key, err = scrypt.Key([]byte(keySource.Passphrase), salt, scryptN, scryptR, scryptP, secretKeySize)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	// Layout: salt | nonce | ciphertext.
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, []byte(secret), []byte(prefix))
+
+	return prefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt a persisted secret (secrets persisted without encryption are returned as-is).
+func (driver *Driver) decryptSecret(secret string) (string, error) {
+	if !isEncryptedSecret(secret) {
+		return secret, nil
+	}
+
+	var prefix string
+	switch {
+	case strings.HasPrefix(secret, encryptedSecretWithKeyPrefix):
+		prefix = encryptedSecretWithKeyPrefix
+	case strings.HasPrefix(secret, encryptedSecretWithScryptPrefix):
+		prefix = encryptedSecretWithScryptPrefix
+	default:
+		return "", errors.New("Unsupported format for encrypted secret in machine configuration")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(secret[len(prefix):])
+	if err != nil {
+		return "", fmt.Errorf("Invalid encrypted secret in machine configuration: %s", err.Error())
+	}
+
+	keySource, err := driver.getSecretKeySource()
+	if err != nil {
+		return "", err
+	}
+	if keySource == nil {
+		return "", fmt.Errorf("Machine configuration contains encrypted secrets, but no encryption key is available (set %s, %s, or %s)",
+			configKeyEnvVar,
+			configKeyFileEnvVar,
+			configPassphraseEnvVar,
+		)
+	}
+
+	if len(data) < secretSaltSize {
+		return "", errors.New("Invalid encrypted secret in machine configuration (too short)")
+	}
+	salt := data[:secretSaltSize]
+	data = data[secretSaltSize:]
+
+	var key []byte
+	if prefix == encryptedSecretWithKeyPrefix {
+		if keySource.Key == nil {
+			return "", fmt.Errorf("Secrets in machine configuration were encrypted using a key (set %s or %s)", configKeyEnvVar, configKeyFileEnvVar)
+		}
+		key = keySource.Key
+	} else {
+		if keySource.Passphrase == "" {
+			return "", fmt.Errorf("Secrets in machine configuration were encrypted using a passphrase (set %s)", configPassphraseEnvVar)
+		}
+		key, err = scrypt.Key([]byte(keySource.Passphrase), salt, scryptN, scryptR, scryptP, secretKeySize)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	aead, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("Invalid encrypted secret in machine configuration (too short)")
+	}
+	nonce := data[:aead.NonceSize()]
+	data = data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, data, []byte(prefix))
+	if err != nil {
+		return "", errors.New("Unable to decrypt secret in machine configuration (incorrect encryption key?)")
+	}
+
+	return string(plaintext), nil
+}
+
+// Create an AES-256-GCM cipher for encrypting / decrypting secrets.
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt any secrets that were persisted (by an older version of the driver) without encryption.
+func (driver *Driver) migrateSecrets() error {
+	if driver.CloudControlPassword == "" || isEncryptedSecret(driver.CloudControlPassword) {
+		if driver.SSHBootstrapPassword == "" || isEncryptedSecret(driver.SSHBootstrapPassword) {
+			return nil
+		}
+	}
+
+	keySource, err := driver.getSecretKeySource()
+	if err != nil || keySource == nil {
+		return err
+	}
+
+	log.Debugf("Encrypting secrets persisted without encryption in configuration for machine '%s'...", driver.MachineName)
+
+	driver.CloudControlPassword, err = driver.encryptSecret(driver.CloudControlPassword)
+	if err != nil {
+		return err
+	}
+
+	driver.SSHBootstrapPassword, err = driver.encryptSecret(driver.SSHBootstrapPassword)
+
+	return err
+}
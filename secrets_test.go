@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+)
+
+// Generate a base64-encoded configuration encryption key.
+func newTestSecretKey(fill byte) string {
+	key := make([]byte, secretKeySize)
+	for index := range key {
+		key[index] = fill + byte(index)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// Clear any configuration encryption key supplied by the test environment.
+func clearSecretKeyEnvironment(t *testing.T) {
+	t.Helper()
+
+	t.Setenv(configKeyEnvVar, "")
+	t.Setenv(configKeyFileEnvVar, "")
+	t.Setenv(configPassphraseEnvVar, "")
+}
+
+// Create a driver for testing encryption of secrets.
+func newSecretsTestDriver() *Driver {
+	return &Driver{
+		BaseDriver: &drivers.BaseDriver{MachineName: "test-machine"},
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "config.key")
+	err := ioutil.WriteFile(keyFile, []byte(newTestSecretKey(2)+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		Name           string
+		EnvVar         string
+		Value          string
+		ExpectedPrefix string
+	}{
+		{"Key", configKeyEnvVar, newTestSecretKey(1), encryptedSecretWithKeyPrefix},
+		{"Key file", configKeyFileEnvVar, keyFile, encryptedSecretWithKeyPrefix},
+		{"Passphrase", configPassphraseEnvVar, "correct horse battery staple", encryptedSecretWithScryptPrefix},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			clearSecretKeyEnvironment(t)
+			t.Setenv(testCase.EnvVar, testCase.Value)
+
+			driver := newSecretsTestDriver()
+			encrypted, err := driver.encryptSecret("s3cret!")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encrypted, testCase.ExpectedPrefix) {
+				t.Fatalf("Encrypted secret '%s' does not start with '%s'", encrypted, testCase.ExpectedPrefix)
+			}
+			if strings.Contains(encrypted, "s3cret!") {
+				t.Fatalf("Encrypted secret '%s' contains the plain-text secret", encrypted)
+			}
+
+			decrypted, err := driver.decryptSecret(encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decrypted != "s3cret!" {
+				t.Fatalf("Decrypted secret is '%s' (expected 's3cret!')", decrypted)
+			}
+		})
+	}
+}
+
+func TestDecryptSecretWithWrongKey(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		EncryptEnvVar string
+		EncryptValue  string
+		DecryptEnvVar string
+		DecryptValue  string
+		ExpectedError string
+	}{
+		{"Wrong key", configKeyEnvVar, newTestSecretKey(1), configKeyEnvVar, newTestSecretKey(2), "incorrect encryption key"},
+		{"Wrong passphrase", configPassphraseEnvVar, "passphrase 1", configPassphraseEnvVar, "passphrase 2", "incorrect encryption key"},
+		{"Passphrase instead of key", configKeyEnvVar, newTestSecretKey(1), configPassphraseEnvVar, "passphrase 1", "encrypted using a key"},
+		{"Key instead of passphrase", configPassphraseEnvVar, "passphrase 1", configKeyEnvVar, newTestSecretKey(1), "encrypted using a passphrase"},
+		{"No key", configKeyEnvVar, newTestSecretKey(1), configKeyEnvVar, "", "no encryption key is available"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			clearSecretKeyEnvironment(t)
+			t.Setenv(testCase.EncryptEnvVar, testCase.EncryptValue)
+
+			driver := newSecretsTestDriver()
+			encrypted, err := driver.encryptSecret("s3cret!")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clearSecretKeyEnvironment(t)
+			t.Setenv(testCase.DecryptEnvVar, testCase.DecryptValue)
+
+			decrypted, err := driver.decryptSecret(encrypted)
+			if err == nil {
+				t.Fatalf("Secret was decrypted with the wrong key (as '%s')", decrypted)
+			}
+			if !strings.Contains(err.Error(), testCase.ExpectedError) {
+				t.Fatalf("Unexpected error '%s' (expected error containing '%s')", err, testCase.ExpectedError)
+			}
+		})
+	}
+}
+
+func TestMigrateSecretsEncryptsPlainTextSecrets(t *testing.T) {
+	clearSecretKeyEnvironment(t)
+	t.Setenv(configKeyEnvVar, newTestSecretKey(1))
+
+	driver := newSecretsTestDriver()
+	driver.CloudControlPassword = "cloud-control-password"
+	driver.SSHBootstrapPassword = "Bootstrap-Passw0rd"
+
+	err := driver.migrateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, secret := range map[string]string{
+		"cloud-control-password": driver.CloudControlPassword,
+		"Bootstrap-Passw0rd":     driver.SSHBootstrapPassword,
+	} {
+		if !strings.HasPrefix(secret, encryptedSecretWithKeyPrefix) {
+			t.Fatalf("Secret '%s' was not encrypted (persisted as '%s')", name, secret)
+		}
+
+		decrypted, err := driver.decryptSecret(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != name {
+			t.Fatalf("Decrypted secret is '%s' (expected '%s')", decrypted, name)
+		}
+	}
+
+	// Secrets that are already encrypted are left as-is.
+	encryptedPassword := driver.CloudControlPassword
+	err = driver.migrateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.CloudControlPassword != encryptedPassword {
+		t.Fatal("Secret that was already encrypted was encrypted again")
+	}
+}
+
+func TestMigrateSecretsWithoutKey(t *testing.T) {
+	clearSecretKeyEnvironment(t)
+
+	driver := newSecretsTestDriver()
+	driver.CloudControlPassword = "cloud-control-password"
+
+	err := driver.migrateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.CloudControlPassword != "cloud-control-password" {
+		t.Fatalf("Secret was changed to '%s' without an encryption key", driver.CloudControlPassword)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+/*
+ * Server state mapping
+ * --------------------
+ *
+ * Maps CloudControl server status (and, for pending changes, the action in progress) to Docker Machine states.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// CloudControl resource statuses for failed operations (not defined by the compute package).
+const (
+	serverStatusFailedAdd       = "FAILED_ADD"
+	serverStatusFailedChange    = "FAILED_CHANGE"
+	serverStatusFailedDelete    = "FAILED_DELETE"
+	serverStatusRequiresSupport = "REQUIRES_SUPPORT"
+)
+
+// CloudControl server actions (as reported in a server's progress) that start or stop the server.
+const (
+	serverActionStart    = "START_SERVER"
+	serverActionReboot   = "REBOOT_SERVER"
+	serverActionReset    = "RESET_SERVER"
+	serverActionShutdown = "SHUTDOWN_SERVER"
+	serverActionPowerOff = "POWER_OFF_SERVER"
+)
+
+// The progress of the operation (if any) in progress on a CloudControl server, or of the last operation if it failed.
+//
+// The compute package does not expose a server's progress, so it is read directly from the API (see getServerProgress).
+type serverProgress struct {
+	// The action in progress (e.g. "SHUTDOWN_SERVER").
+	Action string `json:"action"`
+
+	// The user that requested the action.
+	UserName string `json:"userName,omitempty"`
+
+	// The reason the action failed (if it has failed).
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// Map a CloudControl server's status (and progress, if known) to a Docker Machine state.
+func mapServerState(server *compute.Server, progress *serverProgress) state.State {
+	if server == nil {
+		return state.None // Server does not exist.
+	}
+
+	switch server.State {
+	case serverStatusFailedAdd, serverStatusFailedChange, serverStatusFailedDelete, serverStatusRequiresSupport:
+		return state.Error
+
+	case compute.ResourceStatusPendingAdd:
+		return state.Starting // Server is being deployed.
+
+	case compute.ResourceStatusPendingDelete:
+		return state.Stopping // Server is being destroyed.
+
+	case compute.ResourceStatusPendingChange:
+		// Without the action in progress, we can't tell whether the server is starting or stopping
+		// (Docker Machine has no "unknown" state, so report no state rather than a wrong one).
+		if progress == nil {
+			return state.None
+		}
+
+		switch progress.Action {
+		case serverActionStart, serverActionReboot, serverActionReset:
+			return state.Starting
+		case serverActionShutdown, serverActionPowerOff:
+			return state.Stopping
+		case "":
+			return state.None
+		default:
+			return state.Saved // The server's configuration is being changed.
+		}
+	}
+
+	if !server.Deployed {
+		return state.Starting // Server is being deployed
+	}
+
+	if server.Started {
+		return state.Running // Server is running
+	}
+
+	return state.Stopped // Server is stopped.
+}
+
+// Determine whether a server's progress is required to determine its state (or explain it).
+func isServerProgressRequired(server *compute.Server) bool {
+	switch server.State {
+	case compute.ResourceStatusNormal, compute.ResourceStatusPendingAdd, compute.ResourceStatusPendingDelete:
+		return false
+	default:
+		return true // Pending changes (the action determines the state) and failures (which have a reason).
+	}
+}
+
+// Retrieve the progress of the operation in progress on the target server (or of its last operation, if that failed).
+//
+// Returns nil if the server has no operation in progress.
+func (driver *Driver) getServerProgress() (*serverProgress, error) {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode, responseBody, err := driver.executeCloudControlRequest(client, http.MethodGet, "2.10", "server/server/"+url.PathEscape(driver.ServerID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request to retrieve progress for server '%s' failed with status code %d", driver.ServerID, statusCode)
+	}
+
+	var serverDetails struct {
+		Progress *serverProgress `json:"progress"`
+	}
+	err = json.Unmarshal(responseBody, &serverDetails)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read progress for server '%s': %s", driver.ServerID, err.Error())
+	}
+
+	return serverDetails.Progress, nil
+}
+
+// Log the underlying CloudControl status (and progress, if known) for the target server.
+func (driver *Driver) logServerStatus(server *compute.Server, progress *serverProgress, machineState state.State) {
+	action := "none"
+	failureReason := "none"
+	if progress != nil {
+		action = progress.Action
+		if progress.FailureReason != "" {
+			failureReason = progress.FailureReason
+		}
+	}
+
+	log.Debugf("Server '%s' ('%s'): status = '%s', action = '%s', failure reason = '%s', deployed = %t, started = %t (machine state is '%s').",
+		driver.MachineName,
+		server.ID,
+		server.State,
+		action,
+		failureReason,
+		server.Deployed,
+		server.Started,
+		machineState,
+	)
+}
+
+// The action required to bring a CloudControl server to the desired power state.
+type serverPowerAction int
+
+const (
+	// The server is already in the desired power state.
+	serverPowerActionNone serverPowerAction = iota
+
+	// An operation is in progress; wait for it to complete (and then re-evaluate).
+	serverPowerActionWait
+
+	// The server's power state must be changed.
+	serverPowerActionChange
+
+	// The server's power state cannot be changed (e.g. it's being deleted, or its deployment failed).
+	serverPowerActionFail
+)
+
+// Determine the action required to bring a CloudControl server to the desired power state (started or stopped).
+func getServerPowerAction(server *compute.Server, started bool) serverPowerAction {
+	switch server.State {
+	case compute.ResourceStatusNormal:
+		// Evaluated below.
+	case compute.ResourceStatusPendingAdd, compute.ResourceStatusPendingChange:
+		return serverPowerActionWait
+	default:
+		return serverPowerActionFail
+	}
+
+	if !server.Deployed {
+		return serverPowerActionWait
+	}
+
+	if server.Started == started {
+		return serverPowerActionNone
+	}
+
+	return serverPowerActionChange
+}
+
+// Describe a server power state.
+func describeServerPowerState(started bool) string {
+	if started {
+		return "started"
+	}
+
+	return "stopped"
+}
+
+// Prepare to change the target server's power state, first waiting for any operation in progress to complete.
+//
+// Returns false if the server is already in the desired power state.
+func (driver *Driver) prepareServerPowerChange(started bool) (bool, error) {
+	for waits := 0; ; waits++ {
+		server, err := driver.getServer()
+		if err != nil {
+			return false, err
+		}
+		if server == nil {
+			return false, fmt.Errorf("server '%s' not found", driver.ServerID)
+		}
+
+		switch getServerPowerAction(server, started) {
+		case serverPowerActionNone:
+			log.Debugf("Server '%s' is already %s.", driver.MachineName, describeServerPowerState(started))
+
+			return false, nil
+		case serverPowerActionChange:
+			return true, nil
+		case serverPowerActionFail:
+			return false, fmt.Errorf("Server '%s' ('%s') cannot be %s (status is '%s')",
+				driver.MachineName,
+				driver.ServerID,
+				describeServerPowerState(started),
+				server.State,
+			)
+		}
+
+		if waits == serverPendingOperationMaxWaits {
+			return false, fmt.Errorf("Server '%s' ('%s') is still busy (status is '%s'); try again later",
+				driver.MachineName,
+				driver.ServerID,
+				server.State,
+			)
+		}
+
+		log.Infof("Waiting for pending operation (status is '%s') on server '%s' to complete...", server.State, driver.MachineName)
+
+		err = driver.waitForPendingServerOperation(server)
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Wait for an operation in progress on the target server to complete.
+func (driver *Driver) waitForPendingServerOperation(server *compute.Server) error {
+	client, err := driver.getCloudControlClient()
+	if err != nil {
+		return err
+	}
+
+	if server.State == compute.ResourceStatusPendingAdd || !server.Deployed {
+		return driver.audit("WaitForDeploy", auditResources{"server": driver.ServerID}, func() error {
+			_, waitErr := client.WaitForDeploy(compute.ResourceTypeServer, driver.ServerID, serverCreateTimeout)
+
+			return waitErr
+		})
+	}
+
+	return driver.audit("WaitForChange", auditResources{"server": driver.ServerID}, func() error {
+		_, waitErr := client.WaitForChange(compute.ResourceTypeServer, driver.ServerID, "Pending operation", serverPendingChangeTimeout)
+
+		return waitErr
+	})
+}
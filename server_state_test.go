@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DimensionDataResearch/go-dd-cloud-compute/compute"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+func TestMapServerState(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		State    string
+		Deployed bool
+		Started  bool
+		Action   string // Empty for no progress.
+		Expected state.State
+	}{
+		{"Running server", compute.ResourceStatusNormal, true, true, "", state.Running},
+		{"Stopped server", compute.ResourceStatusNormal, true, false, "", state.Stopped},
+		{"Server not yet deployed", compute.ResourceStatusNormal, false, false, "", state.Starting},
+		{"Server being deployed", compute.ResourceStatusPendingAdd, false, false, "DEPLOY_SERVER", state.Starting},
+		{"Server being deleted", compute.ResourceStatusPendingDelete, true, true, "DELETE_SERVER", state.Stopping},
+		{"Server being started", compute.ResourceStatusPendingChange, true, false, serverActionStart, state.Starting},
+		{"Server being rebooted", compute.ResourceStatusPendingChange, true, true, serverActionReboot, state.Starting},
+		{"Server being reset", compute.ResourceStatusPendingChange, true, true, serverActionReset, state.Starting},
+		{"Server being shut down", compute.ResourceStatusPendingChange, true, true, serverActionShutdown, state.Stopping},
+		{"Server being powered off", compute.ResourceStatusPendingChange, true, true, serverActionPowerOff, state.Stopping},
+		{"Running server being reconfigured", compute.ResourceStatusPendingChange, true, true, "RECONFIGURE_SERVER", state.Saved},
+		{"Stopped server being reconfigured", compute.ResourceStatusPendingChange, true, false, "RECONFIGURE_SERVER", state.Saved},
+		{"Server with pending change but no progress", compute.ResourceStatusPendingChange, true, true, "", state.None},
+		{"Server whose deployment failed", serverStatusFailedAdd, false, false, "DEPLOY_SERVER", state.Error},
+		{"Server whose change failed", serverStatusFailedChange, true, true, serverActionShutdown, state.Error},
+		{"Server whose deletion failed", serverStatusFailedDelete, true, false, "DELETE_SERVER", state.Error},
+		{"Server that requires support", serverStatusRequiresSupport, true, true, "", state.Error},
+	}
+
+	for _, testCase := range testCases {
+		server := &compute.Server{
+			State:    testCase.State,
+			Deployed: testCase.Deployed,
+			Started:  testCase.Started,
+		}
+		var progress *serverProgress
+		if testCase.Action != "" {
+			progress = &serverProgress{Action: testCase.Action}
+		}
+
+		machineState := mapServerState(server, progress)
+		if machineState != testCase.Expected {
+			t.Errorf("%s: got state '%s' (expected '%s')", testCase.Name, machineState, testCase.Expected)
+		}
+	}
+
+	if machineState := mapServerState(nil, nil); machineState != state.None {
+		t.Errorf("Missing server: got state '%s' (expected no state)", machineState)
+	}
+}
+
+func TestGetStateReportsStuckShutdownAsStopping(t *testing.T) {
+	// The server is still running, but a shutdown is in progress.
+	driver, fake := newServerPowerTestDriver(t, true)
+	fake.Servers[0].State = compute.ResourceStatusPendingChange
+	fake.Servers[0].Progress = &serverProgress{Action: serverActionShutdown, UserName: "test-user"}
+
+	machineState, err := driver.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machineState != state.Stopping {
+		t.Fatalf("Server being shut down has state '%s' (expected '%s')", machineState, state.Stopping)
+	}
+}
+
+func TestGetStateLogsFailureReason(t *testing.T) {
+	driver, fake := newServerPowerTestDriver(t, true)
+	fake.Servers[0].State = serverStatusFailedChange
+	fake.Servers[0].Progress = &serverProgress{Action: serverActionShutdown, FailureReason: "VMware tools are not running"}
+
+	machineState, err := driver.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machineState != state.Error {
+		t.Fatalf("Server whose change failed has state '%s' (expected '%s')", machineState, state.Error)
+	}
+
+	for _, message := range log.History() {
+		if strings.Contains(message, "failure reason = 'VMware tools are not running'") {
+			return
+		}
+	}
+	t.Fatal("Server failure reason was not logged")
+}
+
+func TestGetServerPowerAction(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		State    string
+		Deployed bool
+		Started  bool
+		Target   bool
+		Expected serverPowerAction
+	}{
+		{"Start running server", compute.ResourceStatusNormal, true, true, true, serverPowerActionNone},
+		{"Stop stopped server", compute.ResourceStatusNormal, true, false, false, serverPowerActionNone},
+		{"Start stopped server", compute.ResourceStatusNormal, true, false, true, serverPowerActionChange},
+		{"Stop running server", compute.ResourceStatusNormal, true, true, false, serverPowerActionChange},
+		{"Start server being deployed", compute.ResourceStatusPendingAdd, false, false, true, serverPowerActionWait},
+		{"Start server not yet deployed", compute.ResourceStatusNormal, false, false, true, serverPowerActionWait},
+		{"Start server with pending change", compute.ResourceStatusPendingChange, true, false, true, serverPowerActionWait},
+		{"Stop server with pending change", compute.ResourceStatusPendingChange, true, true, false, serverPowerActionWait},
+		{"Start server being deleted", compute.ResourceStatusPendingDelete, true, false, true, serverPowerActionFail},
+		{"Start server whose deployment failed", serverStatusFailedAdd, false, false, true, serverPowerActionFail},
+		{"Stop server whose change failed", serverStatusFailedChange, true, true, false, serverPowerActionFail},
+		{"Stop server whose deletion failed", serverStatusFailedDelete, true, true, false, serverPowerActionFail},
+		{"Start server that requires support", serverStatusRequiresSupport, true, false, true, serverPowerActionFail},
+	}
+
+	for _, testCase := range testCases {
+		server := &compute.Server{
+			State:    testCase.State,
+			Deployed: testCase.Deployed,
+			Started:  testCase.Started,
+		}
+
+		action := getServerPowerAction(server, testCase.Target)
+		if action != testCase.Expected {
+			t.Errorf("%s: got action %d (expected %d)", testCase.Name, action, testCase.Expected)
+		}
+	}
+}
+
+// Create a driver targeting a server in the fake CloudControl API.
+func newServerPowerTestDriver(t *testing.T, started bool, scriptedStates ...string) (*Driver, *fakeCloudControl) {
+	t.Helper()
+
+	fake := newFakeCloudControl(t)
+	server := fake.addServer(compute.Server{
+		Name:     "test-server",
+		State:    compute.ResourceStatusNormal,
+		Deployed: true,
+		Started:  started,
+	}, scriptedStates...)
+
+	driver := fake.newDriver(t, "test-machine", "")
+	driver.ServerID = server.ID
+
+	return driver, fake
+}
+
+func TestStartServerWhenAlreadyStarted(t *testing.T) {
+	t.Parallel()
+
+	driver, fake := newServerPowerTestDriver(t, true)
+
+	err := driver.startServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Server that was already started was changed (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStopServerWhenAlreadyStopped(t *testing.T) {
+	t.Parallel()
+
+	driver, fake := newServerPowerTestDriver(t, false)
+
+	err := driver.stopServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Server that was already stopped was changed (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStartServerWaitsForPendingOperation(t *testing.T) {
+	t.Parallel()
+
+	// The server is busy when first retrieved, and its operation completes by the next poll.
+	driver, fake := newServerPowerTestDriver(t, false,
+		compute.ResourceStatusPendingChange,
+	)
+
+	err := driver.startServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := fake.getOperations()
+	if strings.Join(operations, ",") != "server/startServer" {
+		t.Fatalf("Unexpected operations '%s' (expected server/startServer)", strings.Join(operations, ", "))
+	}
+
+	server, err := driver.getServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.Started {
+		t.Fatal("Server was not started")
+	}
+}
+
+func TestStartServerFailsWhenPendingOperationsExceedMaxWaits(t *testing.T) {
+	t.Parallel()
+
+	// Each time the server's operation completes, another one has started by the time it is retrieved again.
+	var scriptedStates []string
+	for waits := 0; waits <= serverPendingOperationMaxWaits; waits++ {
+		scriptedStates = append(scriptedStates, compute.ResourceStatusPendingChange, compute.ResourceStatusNormal)
+	}
+	driver, fake := newServerPowerTestDriver(t, false, scriptedStates...)
+
+	err := driver.startServer()
+	if err == nil {
+		t.Fatal("Server was started while it was still busy")
+	}
+	if !strings.Contains(err.Error(), "is still busy") || !strings.Contains(err.Error(), compute.ResourceStatusPendingChange) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Busy server was changed (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStartServerFailsWhenServerHasFailed(t *testing.T) {
+	t.Parallel()
+
+	driver, fake := newServerPowerTestDriver(t, false,
+		serverStatusFailedChange,
+	)
+
+	err := driver.startServer()
+	if err == nil {
+		t.Fatal("Server with failed operation was started")
+	}
+	if !strings.Contains(err.Error(), "cannot be started") || !strings.Contains(err.Error(), serverStatusFailedChange) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Server with failed operation was changed (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStopServerFailsWhenPendingOperationFails(t *testing.T) {
+	t.Parallel()
+
+	// The server is busy when first retrieved, and its operation has failed by the next poll.
+	driver, fake := newServerPowerTestDriver(t, true,
+		compute.ResourceStatusPendingChange,
+		serverStatusFailedChange,
+	)
+
+	err := driver.stopServer()
+	if err == nil {
+		t.Fatal("Server was stopped after its pending operation failed")
+	}
+	if !strings.Contains(err.Error(), serverStatusFailedChange) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	operations := fake.getOperations()
+	if len(operations) != 0 {
+		t.Fatalf("Server was changed after its pending operation failed (%s)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStopServerPowersOffWhenShutdownTimesOut(t *testing.T) {
+	t.Parallel()
+
+	driver, fake := newServerPowerTestDriver(t, true)
+	driver.StopGracePeriod = 1
+	fake.Servers[0].ShutdownState = compute.ResourceStatusPendingChange
+
+	err := driver.stopServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := fake.getOperations()
+	if strings.Join(operations, ",") != "server/shutdownServer,server/powerOffServer" {
+		t.Fatalf("Unexpected operations '%s' (expected server/shutdownServer, server/powerOffServer)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStopServerDoesNotPowerOffWhenShutdownFails(t *testing.T) {
+	t.Parallel()
+
+	driver, fake := newServerPowerTestDriver(t, true)
+	fake.Servers[0].ShutdownState = serverStatusFailedChange
+
+	err := driver.stopServer()
+	if err == nil {
+		t.Fatal("Server was stopped after its shutdown failed")
+	}
+	if isWaitTimeoutError(err) {
+		t.Fatalf("Failed shutdown was reported as a timeout: %s", err)
+	}
+
+	operations := fake.getOperations()
+	if strings.Join(operations, ",") != "server/shutdownServer" {
+		t.Fatalf("Unexpected operations '%s' (expected server/shutdownServer)", strings.Join(operations, ", "))
+	}
+}
+
+func TestStopServerDoesNotPowerOffWhenShutdownIsRejected(t *testing.T) {
+	t.Parallel()
+
+	// Another operation starts after the server is first retrieved, so the request to shut it down is rejected.
+	driver, fake := newServerPowerTestDriver(t, true,
+		compute.ResourceStatusNormal,
+		compute.ResourceStatusNormal,
+	)
+
+	err := driver.stopServer()
+	if err == nil {
+		t.Fatal("Server was stopped while it was busy")
+	}
+	if !compute.IsResourceBusyError(err) {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	operations := fake.getOperations()
+	if strings.Join(operations, ",") != "server/shutdownServer" {
+		t.Fatalf("Unexpected operations '%s' (expected server/shutdownServer)", strings.Join(operations, ", "))
+	}
+}
+
+func TestIsWaitTimeoutError(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Err      error
+		Expected bool
+	}{
+		{"Timeout", fmt.Errorf("Timed out after waiting %d seconds for %s of %s '%s' to complete", 120, "Shut down server", "server", "server-1"), true},
+		{"Unexpected state", fmt.Errorf("Shut down server of server 'server-1' encountered unexpected state '%s'", serverStatusFailedChange), false},
+		{"Nil", nil, false},
+	}
+
+	for _, testCase := range testCases {
+		if isWaitTimeoutError(testCase.Err) != testCase.Expected {
+			t.Errorf("%s: isWaitTimeoutError(%v) is %t (expected %t)", testCase.Name, testCase.Err, !testCase.Expected, testCase.Expected)
+		}
+	}
+}
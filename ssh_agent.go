@@ -0,0 +1,134 @@
+package main
+
+/*
+ * ssh-agent support
+ * -----------------
+ *
+ * Allows the use of keys held by ssh-agent (including keys on hardware tokens) instead of key files in the machine store folder.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Get the ssh-agent client used by the driver.
+func (driver *Driver) getSSHAgent() (agent.ExtendedAgent, error) {
+	if driver.sshAgent != nil {
+		return driver.sshAgent, nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("Cannot connect to ssh-agent (SSH_AUTH_SOCK is not set)")
+	}
+
+	connection, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to ssh-agent at '%s': %s", socket, err.Error())
+	}
+
+	driver.sshAgent = agent.NewClient(connection)
+
+	return driver.sshAgent, nil
+}
+
+// Resolve (find) the configured ssh-agent identity, and record its public key.
+func (driver *Driver) resolveSSHAgentIdentity() error {
+	driver.SSHAgentPublicKey = ""
+
+	sshAgent, err := driver.getSSHAgent()
+	if err != nil {
+		return err
+	}
+
+	keys, err := sshAgent.List()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("ssh-agent does not hold any identities")
+	}
+
+	var matchingKeys []*agent.Key
+	for _, key := range keys {
+		if driver.SSHAgentIdentity == "" || key.Comment == driver.SSHAgentIdentity || matchesSSHFingerprint(key, driver.SSHAgentIdentity) {
+			matchingKeys = append(matchingKeys, key)
+		}
+	}
+
+	var availableIdentities []string
+	for _, key := range keys {
+		availableIdentities = append(availableIdentities,
+			fmt.Sprintf("'%s' (%s)", key.Comment, gossh.FingerprintSHA256(key)),
+		)
+	}
+
+	switch len(matchingKeys) {
+	case 0:
+		return fmt.Errorf("ssh-agent does not hold an identity matching '%s' (available identities are %s)",
+			driver.SSHAgentIdentity,
+			strings.Join(availableIdentities, ", "),
+		)
+	case 1:
+		break
+	default:
+		return fmt.Errorf("ssh-agent holds more than one identity; use --ddcloud-ssh-agent-identity to select one of %s",
+			strings.Join(availableIdentities, ", "),
+		)
+	}
+
+	identity := matchingKeys[0]
+	driver.SSHAgentPublicKey = strings.TrimSpace(
+		string(gossh.MarshalAuthorizedKey(identity)),
+	)
+
+	log.Infof("Using ssh-agent identity '%s' (%s).", identity.Comment, gossh.FingerprintSHA256(identity))
+
+	return nil
+}
+
+// Create SSH client configuration for authentication using the configured ssh-agent identity.
+func (driver *Driver) newSSHAgentConfig(user string, hostKeyCallback gossh.HostKeyCallback) (*gossh.ClientConfig, error) {
+	if driver.SSHAgentPublicKey == "" {
+		return nil, errors.New("ssh-agent identity has not been resolved")
+	}
+
+	publicKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(driver.SSHAgentPublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	sshAgent, err := driver.getSSHAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	signers, err := sshAgent.Signers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			return &gossh.ClientConfig{
+				User: user,
+				Auth: []gossh.AuthMethod{
+					gossh.PublicKeys(signer),
+				},
+				HostKeyCallback: hostKeyCallback,
+				Timeout:         sshConnectTimeout,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("ssh-agent no longer holds identity '%s'", gossh.FingerprintSHA256(publicKey))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Create an in-memory ssh-agent holding a new key for each of the specified comments.
+func newTestSSHAgent(t *testing.T, comments ...string) (agent.ExtendedAgent, []gossh.PublicKey) {
+	t.Helper()
+
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	publicKeys := make([]gossh.PublicKey, len(comments))
+	for index, comment := range comments {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKeys[index], err = gossh.NewPublicKey(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = keyring.Add(agent.AddedKey{PrivateKey: privateKey, Comment: comment})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return keyring, publicKeys
+}
+
+// Create a driver that uses the specified ssh-agent.
+func newSSHAgentTestDriver(sshAgent agent.ExtendedAgent, identity string) *Driver {
+	return &Driver{
+		BaseDriver:       &drivers.BaseDriver{MachineName: "test-machine"},
+		SSHUseAgent:      true,
+		SSHAgentIdentity: identity,
+		sshAgent:         sshAgent,
+	}
+}
+
+func TestResolveSSHAgentIdentity(t *testing.T) {
+	sshAgent, publicKeys := newTestSSHAgent(t, "work@example", "home@example")
+
+	testCases := []struct {
+		Name             string
+		Identity         string
+		ExpectedKeyIndex int
+	}{
+		{"Comment", "home@example", 1},
+		{"SHA256 fingerprint", gossh.FingerprintSHA256(publicKeys[0]), 0},
+		{"MD5 fingerprint", "MD5:" + gossh.FingerprintLegacyMD5(publicKeys[1]), 1},
+	}
+
+	for _, testCase := range testCases {
+		driver := newSSHAgentTestDriver(sshAgent, testCase.Identity)
+		err := driver.resolveSSHAgentIdentity()
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.Name, err)
+		}
+
+		expectedPublicKey := formatPinnedSSHHostKey(publicKeys[testCase.ExpectedKeyIndex])
+		if driver.SSHAgentPublicKey != expectedPublicKey {
+			t.Errorf("%s: selected identity '%s' (expected '%s')", testCase.Name, driver.SSHAgentPublicKey, expectedPublicKey)
+		}
+	}
+}
+
+func TestResolveSSHAgentIdentityErrors(t *testing.T) {
+	emptyAgent, _ := newTestSSHAgent(t)
+	singleKeyAgent, _ := newTestSSHAgent(t, "work@example")
+	sshAgent, publicKeys := newTestSSHAgent(t, "work@example", "home@example")
+
+	testCases := []struct {
+		Name           string
+		SSHAgent       agent.ExtendedAgent
+		Identity       string
+		ExpectedErrors []string
+	}{
+		{"No identities", emptyAgent, "", []string{"does not hold any identities"}},
+		{"No matching identity", sshAgent, "other@example", []string{
+			"does not hold an identity matching 'other@example'",
+			"'work@example' (" + gossh.FingerprintSHA256(publicKeys[0]) + ")",
+			"'home@example' (" + gossh.FingerprintSHA256(publicKeys[1]) + ")",
+		}},
+		{"Ambiguous identity", sshAgent, "", []string{"more than one identity", "--ddcloud-ssh-agent-identity"}},
+		{"Fingerprint of other key", singleKeyAgent, gossh.FingerprintSHA256(publicKeys[0]), []string{"does not hold an identity matching"}},
+	}
+
+	for _, testCase := range testCases {
+		driver := newSSHAgentTestDriver(testCase.SSHAgent, testCase.Identity)
+		err := driver.resolveSSHAgentIdentity()
+		if err == nil {
+			t.Errorf("%s: selected identity '%s' (expected an error)", testCase.Name, driver.SSHAgentPublicKey)
+
+			continue
+		}
+		for _, expectedError := range testCase.ExpectedErrors {
+			if !strings.Contains(err.Error(), expectedError) {
+				t.Errorf("%s: error '%s' does not contain '%s'", testCase.Name, err, expectedError)
+			}
+		}
+		if driver.SSHAgentPublicKey != "" {
+			t.Errorf("%s: identity was recorded despite the error", testCase.Name)
+		}
+	}
+}
+
+func TestResolveSSHAgentIdentityWithSingleKey(t *testing.T) {
+	sshAgent, publicKeys := newTestSSHAgent(t, "work@example")
+
+	// If the agent only holds one identity, it is used even if none was configured.
+	driver := newSSHAgentTestDriver(sshAgent, "")
+	err := driver.resolveSSHAgentIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.SSHAgentPublicKey != formatPinnedSSHHostKey(publicKeys[0]) {
+		t.Fatalf("Selected identity '%s' (expected '%s')", driver.SSHAgentPublicKey, formatPinnedSSHHostKey(publicKeys[0]))
+	}
+}
+
+func TestNewSSHAgentConfig(t *testing.T) {
+	sshAgent, publicKeys := newTestSSHAgent(t, "work@example", "home@example")
+
+	driver := newSSHAgentTestDriver(sshAgent, "home@example")
+	err := driver.resolveSSHAgentIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := driver.newSSHAgentConfig("docker", gossh.InsecureIgnoreHostKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.User != "docker" || len(config.Auth) != 1 {
+		t.Fatalf("Unexpected SSH client configuration (user '%s', %d auth methods)", config.User, len(config.Auth))
+	}
+
+	// The identity is removed from the agent after it was resolved.
+	err = sshAgent.Remove(publicKeys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = driver.newSSHAgentConfig("docker", gossh.InsecureIgnoreHostKey())
+	if err == nil || !strings.Contains(err.Error(), "no longer holds identity") {
+		t.Fatalf("Expected missing identity error (got %v)", err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+/*
+ * SSH bastion (jump host) support
+ * -------------------------------
+ *
+ * When a bastion is configured, all SSH connections to the target server are tunnelled through it.
+ *
+ * Because Docker Machine makes its own SSH (and Docker API) connections using the host name and port reported by the driver,
+ * the driver also exposes local port-forwards (via the bastion) for the target server's SSH and Docker API ports.
+ * These only last as long as the driver process; use "docker-machine-driver-ddcloud port-forward" to keep the Docker API forward open.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The local address used for port-forwards.
+const portForwardLocalHost = "127.0.0.1"
+
+// The host name reported for port-forwarded Docker API URLs (Docker Machine's server certificates include "localhost").
+const portForwardLocalHostName = "localhost"
+
+// Is an SSH bastion configured?
+func (driver *Driver) isSSHBastionConfigured() bool {
+	return driver.SSHBastion != ""
+}
+
+// Parse an SSH bastion specification ("user@host[:port]").
+func parseSSHBastion(bastion string) (user string, host string, port int, err error) {
+	atIndex := strings.LastIndex(bastion, "@")
+	if atIndex < 1 {
+		err = fmt.Errorf("Invalid SSH bastion '%s' (expected 'user@host[:port]')", bastion)
+
+		return
+	}
+	user = bastion[:atIndex]
+	host = bastion[atIndex+1:]
+	port = 22
+
+	if strings.LastIndex(host, ":") > strings.LastIndex(host, "]") {
+		var portString string
+		host, portString, err = net.SplitHostPort(host)
+		if err != nil {
+			err = fmt.Errorf("Invalid SSH bastion '%s': %s", bastion, err.Error())
+
+			return
+		}
+
+		port, err = strconv.Atoi(portString)
+		if err != nil || port < 1 || port > 65535 {
+			err = fmt.Errorf("Invalid SSH bastion '%s' (invalid port '%s')", bastion, portString)
+
+			return
+		}
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		err = fmt.Errorf("Invalid SSH bastion '%s' (host not specified)", bastion)
+	}
+
+	return
+}
+
+// Import the configured SSH bastion key file into the machine store folder.
+func (driver *Driver) importSSHBastionKey() error {
+	if driver.SSHBastionKey == "" {
+		return nil
+	}
+
+	storedKeyPath := driver.ResolveStorePath(
+		"bastion_" + filepath.Base(driver.SSHBastionKey),
+	)
+	err := copySSHKey(driver.SSHBastionKey, storedKeyPath)
+	if err != nil {
+		log.Infof("Couldn't copy SSH bastion key: %s", err.Error())
+
+		return err
+	}
+	driver.SSHBastionKey = storedKeyPath
+
+	return nil
+}
+
+// Get the SSH client connected to the configured bastion.
+func (driver *Driver) getSSHBastionClient() (*gossh.Client, error) {
+	if driver.sshBastionClient != nil {
+		return driver.sshBastionClient, nil
+	}
+
+	if !driver.isSSHBastionConfigured() {
+		return nil, errors.New("SSH bastion has not been configured")
+	}
+
+	user, host, port, err := parseSSHBastion(driver.SSHBastion)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := getSSHBastionHostKeyCallback(driver.SSHBastion)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *gossh.ClientConfig
+	if driver.SSHBastionKey != "" {
+		config, err = newSSHKeyConfig(user, driver.SSHBastionKey, hostKeyCallback)
+	} else if driver.SSHUseAgent {
+		config, err = driver.newSSHAgentBastionConfig(user, hostKeyCallback)
+	} else {
+		err = errors.New("No SSH key has been configured for the SSH bastion (use --ddcloud-ssh-bastion-key or --ddcloud-ssh-use-agent)")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(host,
+		strconv.Itoa(port),
+	)
+	log.Debugf("Connecting to SSH bastion '%s' as '%s'...", address, user)
+
+	client, err := gossh.Dial("tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to SSH bastion '%s': %s", address, err.Error())
+	}
+	driver.sshBastionClient = client
+
+	return client, nil
+}
+
+// Create SSH client configuration for authenticating to the bastion using any identity held by ssh-agent.
+func (driver *Driver) newSSHAgentBastionConfig(user string, hostKeyCallback gossh.HostKeyCallback) (*gossh.ClientConfig, error) {
+	sshAgent, err := driver.getSSHAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{
+			gossh.PublicKeysCallback(sshAgent.Signers),
+		},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	}, nil
+}
+
+// Get a callback that verifies the bastion's host key against the user's known_hosts file.
+//
+// The bastion's host key is never ignored; if it cannot be verified, connecting to the bastion fails.
+func getSSHBastionHostKeyCallback(bastion string) (gossh.HostKeyCallback, error) {
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	knownHostsFile := filepath.Join(homeDirectory, ".ssh", knownHostsFileName)
+	_, err = os.Stat(knownHostsFile)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot verify the host key for SSH bastion '%s' ('%s' not found); add the bastion's host key to it (e.g. by connecting to the bastion using ssh) and try again",
+			bastion,
+			knownHostsFile,
+		)
+	}
+
+	verifyHostKey, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read '%s': %s", knownHostsFile, err.Error())
+	}
+
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		err := verifyHostKey(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+		if len(keyErr.Want) == 0 {
+			return fmt.Errorf("SSH bastion '%s' is not listed in '%s'; add the bastion's host key to it (e.g. by connecting to the bastion using ssh) and try again",
+				bastion,
+				knownHostsFile,
+			)
+		}
+
+		return fmt.Errorf("The host key presented by SSH bastion '%s' (%s) does not match the one in '%s' (line %d)",
+			bastion,
+			gossh.FingerprintSHA256(key),
+			knownHostsFile,
+			keyErr.Want[0].Line,
+		)
+	}, nil
+}
+
+// Dial the specified address (via the bastion, if one is configured).
+func (driver *Driver) dialSSHTarget(address string) (net.Conn, error) {
+	if !driver.isSSHBastionConfigured() {
+		return net.DialTimeout("tcp", address, sshConnectTimeout)
+	}
+
+	bastion, err := driver.getSSHBastionClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return bastion.Dial("tcp", address)
+}
+
+// Get the local port-forward (via the bastion) to the target server's SSH port, starting it if required.
+func (driver *Driver) getSSHPortForward() (net.Listener, error) {
+	if driver.sshPortForward != nil {
+		return driver.sshPortForward, nil
+	}
+
+	listener, err := driver.startPortForward(
+		net.JoinHostPort(portForwardLocalHost, "0"),
+		net.JoinHostPort(driver.IPAddress, strconv.Itoa(driver.SSHPort)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	driver.sshPortForward = listener
+
+	return listener, nil
+}
+
+// Get the local port-forward (via the bastion) to the target server's Docker API port, starting it if required.
+//
+// Docker Machine configures the Docker daemon to listen on the port from the URL reported by the driver, so the local port must be the same as the remote one.
+func (driver *Driver) getDockerPortForward() (net.Listener, error) {
+	if driver.dockerPortForward != nil {
+		return driver.dockerPortForward, nil
+	}
+
+	dockerPort := strconv.Itoa(
+		driver.getDockerPort(),
+	)
+	listener, err := driver.startPortForward(
+		net.JoinHostPort(portForwardLocalHost, dockerPort),
+		net.JoinHostPort(driver.IPAddress, dockerPort),
+	)
+	if err != nil {
+		return nil, err
+	}
+	driver.dockerPortForward = listener
+
+	return listener, nil
+}
+
+// Verify that the local port for the Docker API port-forward is available.
+func (driver *Driver) checkDockerPortForwardAvailable() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(portForwardLocalHost,
+		strconv.Itoa(driver.getDockerPort()),
+	))
+	if err != nil {
+		return fmt.Errorf("Local port %d is not available for the Docker API port-forward (use --ddcloud-docker-port to select a different port): %s",
+			driver.getDockerPort(),
+			err.Error(),
+		)
+	}
+
+	return listener.Close()
+}
+
+// Start forwarding connections from a local address to a remote address (via the bastion).
+func (driver *Driver) startPortForward(localAddress string, remoteAddress string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Forwarding '%s' to '%s' via SSH bastion '%s'...", listener.Addr(), remoteAddress, driver.SSHBastion)
+
+	go func() {
+		for {
+			localConnection, err := listener.Accept()
+			if err != nil {
+				return // Listener closed.
+			}
+
+			go driver.forwardConnection(localConnection, remoteAddress)
+		}
+	}()
+
+	return listener, nil
+}
+
+// Forward a local connection to a remote address (via the bastion).
+func (driver *Driver) forwardConnection(localConnection net.Conn, remoteAddress string) {
+	defer localConnection.Close()
+
+	remoteConnection, err := driver.dialSSHTarget(remoteAddress)
+	if err != nil {
+		log.Errorf("Unable to forward connection to '%s': %s", remoteAddress, err.Error())
+
+		return
+	}
+	defer remoteConnection.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remoteConnection, localConnection)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(localConnection, remoteConnection)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// Run the Docker API port-forward for an existing machine until the process is terminated.
+func runDockerPortForward(machineDirectory string) error {
+	driver, err := loadDriverFromStore(machineDirectory)
+	if err != nil {
+		return err
+	}
+	if !driver.isSSHBastionConfigured() || !driver.DockerPortForward {
+		return fmt.Errorf("Machine '%s' is not configured to forward the Docker API port via an SSH bastion", driver.MachineName)
+	}
+
+	_, err = driver.getDockerPortForward()
+	if err != nil {
+		return err
+	}
+
+	url, err := driver.GetURL()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Forwarding %s to '%s:%d' via SSH bastion '%s' (press Ctrl-C to stop)...\n",
+		url,
+		driver.IPAddress,
+		driver.getDockerPort(),
+		driver.SSHBastion,
+	)
+
+	// Block until the process is terminated.
+	select {}
+}
+
+// Load driver state for an existing machine from its Docker Machine store folder.
+func loadDriverFromStore(machineDirectory string) (*Driver, error) {
+	configData, err := ioutil.ReadFile(
+		filepath.Join(machineDirectory, "config.json"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' does not appear to be a Docker Machine store folder: %s", machineDirectory, err.Error())
+	}
+
+	hostConfig := &struct {
+		Driver *Driver
+	}{
+		Driver: &Driver{BaseDriver: &drivers.BaseDriver{}},
+	}
+	err = json.Unmarshal(configData, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostConfig.Driver, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Generate an SSH host key.
+func generateTestHostKey(t *testing.T) gossh.PublicKey {
+	t.Helper()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := gossh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hostKey
+}
+
+// Use a temporary directory as the user's home directory for the rest of the test.
+func useTestHomeDirectory(t *testing.T) string {
+	t.Helper()
+
+	homeDirectory := t.TempDir()
+	t.Setenv("HOME", homeDirectory)
+	t.Setenv("USERPROFILE", homeDirectory) // Windows
+
+	return homeDirectory
+}
+
+func TestSSHBastionHostKeyCallbackRequiresKnownHostsFile(t *testing.T) {
+	useTestHomeDirectory(t)
+
+	_, err := getSSHBastionHostKeyCallback("jump@bastion.example.com")
+	if err == nil {
+		t.Fatal("Host key callback was created without a known_hosts file (the bastion's host key would not be verified)")
+	}
+	if !strings.Contains(err.Error(), "not found") || !strings.Contains(err.Error(), "jump@bastion.example.com") {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestSSHBastionHostKeyCallbackVerifiesHostKey(t *testing.T) {
+	homeDirectory := useTestHomeDirectory(t)
+
+	hostKey := generateTestHostKey(t)
+	err := os.MkdirAll(filepath.Join(homeDirectory, ".ssh"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(homeDirectory, ".ssh", "known_hosts"),
+		[]byte(knownhosts.Line([]string{knownhosts.Normalize("bastion.example.com:22")}, hostKey)+"\n"),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostKeyCallback, err := getSSHBastionHostKeyCallback("jump@bastion.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteAddress := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}
+
+	err = hostKeyCallback("bastion.example.com:22", remoteAddress, hostKey)
+	if err != nil {
+		t.Fatalf("Known host key was rejected: %s", err)
+	}
+
+	err = hostKeyCallback("bastion.example.com:22", remoteAddress, generateTestHostKey(t))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Expected host key mismatch error (got %v)", err)
+	}
+
+	err = hostKeyCallback("other.example.com:22", remoteAddress, hostKey)
+	if err == nil || !strings.Contains(err.Error(), "is not listed") {
+		t.Fatalf("Expected unknown host error (got %v)", err)
+	}
+}
@@ -4,9 +4,12 @@ package main
  * SSH key bootstrapper
  * --------------------
  *
- * Installs an SSH key onto the target machine so the rest of docker-machine can do its stuff (then disables password authentication).
+ * Installs an SSH key onto the target machine so the rest of docker-machine can do its stuff (then locks the bootstrap password and disables password authentication in sshd).
  *
  * This is required because CloudControl only supports specifying passwords during server deployment (not SSH keys).
+ *
+ * The bootstrap process always connects as root; if a different SSH user has been configured, that user is created (with passwordless sudo),
+ * and root login via SSH is disabled once the key has been installed.
  */
 
 import (
@@ -14,12 +17,31 @@ import (
 	"fmt"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnutils"
-	"github.com/docker/machine/libmachine/ssh"
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
 )
 
+// The user used to bootstrap SSH (CloudControl only sets an initial password for root).
+const sshBootstrapUser = "root"
+
+// The port on which sshd listens when the server is first deployed.
+const sshDefaultPort = 22
+
+// The number of attempts made to verify key-based SSH authentication (sshd may take a moment to start listening after being reloaded).
+const sshVerifyAttempts = 5
+
+// The sshd configuration file on the target server.
+const sshdConfigFile = "/etc/ssh/sshd_config"
+
+// The file used to back up the sshd configuration on the target server while it is being modified.
+const sshdConfigBackupFile = sshdConfigFile + ".ddcloud-bootstrap"
+
 // Bootstrap key-based SSH authentication by installing an SSH public key on the target machine.
 func (driver *Driver) installSSHKey() error {
 	if !driver.isServerCreated() {
@@ -27,81 +49,405 @@ func (driver *Driver) installSSHKey() error {
 	}
 
 	log.Debugf("Starting SSH bootstrap process (as user '%s') for target host '%s:%d'...",
-		driver.SSHUser,
+		sshBootstrapUser,
+		driver.IPAddress,
+		sshDefaultPort,
+	)
+
+	// This session stays open until we've verified that key-based authentication works (so we can roll back if it doesn't).
+	bootstrapPassword, err := driver.getSSHBootstrapPassword()
+	if err != nil {
+		return err
+	}
+	passwordConfig, err := newSSHPasswordConfig(sshBootstrapUser, bootstrapPassword,
+		driver.sshHostKeyCallback(),
+	)
+	if err != nil {
+		return err
+	}
+	client, err := driver.waitForSSH(sshDefaultPort, passwordConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if driver.SSHUser != sshBootstrapUser {
+		err = driver.createSSHUser(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	homeDirectory, err := getUserHomeDirectory(client, driver.SSHUser)
+	if err != nil {
+		return err
+	}
+	sshDirectory := homeDirectory + "/.ssh"
+	authorizedKeysFile := sshDirectory + "/authorized_keys"
+
+	err = runSSHCommand(client, fmt.Sprintf("create '%s'", sshDirectory), fmt.Sprintf(
+		`mkdir -p "%s"`, sshDirectory,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, fmt.Sprintf("secure '%s'", sshDirectory), fmt.Sprintf(
+		`chmod 700 "%s"`, sshDirectory,
+	))
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := driver.getSSHPublicKey()
+	if err != nil {
+		return err
+	}
+	err = checkSSHKeyTypeAccepted(client, publicKey)
+	if err != nil {
+		return err
+	}
+	err = runSSHCommand(client, fmt.Sprintf("add SSH key to '%s'", authorizedKeysFile), fmt.Sprintf(
+		`echo '%s' >> "%s"`, strings.TrimSpace(publicKey), authorizedKeysFile,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, fmt.Sprintf("secure '%s'", authorizedKeysFile), fmt.Sprintf(
+		`chmod 600 "%s"`, authorizedKeysFile,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, fmt.Sprintf("change owner of '%s' to '%s'", sshDirectory, driver.SSHUser), fmt.Sprintf(
+		`chown -R "%[1]s:$(id -gn %[1]s)" "%[2]s"`, driver.SSHUser, sshDirectory,
+	))
+	if err != nil {
+		return err
+	}
+
+	if driver.ServerAdopted && !driver.HardenAdoptedServer {
+		log.Infof("Leaving sshd configuration and the password for '%s' unchanged on adopted server '%s' (use --ddcloud-harden-adopted-server to harden it).",
+			sshBootstrapUser,
+			driver.MachineName,
+		)
+
+		err = driver.verifySSHKeyAuthentication(sshDefaultPort)
+	} else {
+		err = driver.hardenAndVerifySSHServer(client)
+	}
+	if err != nil {
+		return err
+	}
+
+	driver.clearSSHBootstrapPassword()
+
+	err = driver.writeKnownHostsFile()
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("SSH bootstrap process complete; the public key for %s is now installed on host '%s:%d' for user '%s'.",
+		driver.describeSSHKey(),
 		driver.IPAddress,
 		driver.SSHPort,
+		driver.SSHUser,
 	)
 
-	// We explicitly need the native client because we're using password authentication.
-	client, err := ssh.NewNativeClient(driver.SSHUser, driver.IPAddress, driver.SSHPort, &ssh.Auth{
-		Passwords: []string{driver.SSHBootstrapPassword},
-	})
+	return nil
+}
+
+// Harden sshd (see hardenSSHServer), verifying that key-based authentication works before and after reconfiguring it.
+func (driver *Driver) hardenAndVerifySSHServer(client *sshSession) error {
+	log.Debugf("Verifying key-based SSH authentication before disabling password authentication...")
+	err := driver.verifySSHKeyAuthentication(sshDefaultPort)
 	if err != nil {
 		return err
 	}
 
-	log.Debugf("Create '$HOME/.ssh'...")
-	output, err := client.Output(`mkdir -p "$HOME/.ssh"`)
+	err = driver.hardenSSHServer(client)
 	if err != nil {
-		return fmt.Errorf("Failed to create '$HOME/.ssh'\n%s\nOutput:\n%s",
-			err.Error(),
-			output,
+		return err
+	}
+
+	log.Debugf("Verifying key-based SSH authentication (on port %d) after reconfiguring sshd...", driver.SSHPort)
+	err = driver.verifySSHKeyAuthentication(driver.SSHPort)
+	if err != nil {
+		log.Warnf("Key-based SSH authentication failed after reconfiguring sshd; rolling back sshd configuration...")
+
+		rollbackErr := driver.rollBackSSHServerHardening(client)
+		if rollbackErr != nil {
+			log.Errorf("Failed to roll back sshd configuration: %s", rollbackErr.Error())
+		}
+
+		return err
+	}
+
+	return runSSHCommand(client, "remove sshd configuration backup", fmt.Sprintf(
+		"rm -f %s", sshdConfigBackupFile,
+	))
+}
+
+// Create the target (non-root) SSH user, and grant it passwordless sudo.
+func (driver *Driver) createSSHUser(client *sshSession) error {
+	err := runSSHCommand(client, fmt.Sprintf("create user '%s'", driver.SSHUser), fmt.Sprintf(
+		`id -u %[1]s >/dev/null 2>&1 || useradd --create-home --shell /bin/bash %[1]s`, driver.SSHUser,
+	))
+	if err != nil {
+		return err
+	}
+
+	sudoersFile := fmt.Sprintf("/etc/sudoers.d/90-docker-machine-%s", driver.SSHUser)
+	err = runSSHCommand(client, fmt.Sprintf("grant passwordless sudo to '%s'", driver.SSHUser), fmt.Sprintf(
+		`mkdir -p /etc/sudoers.d && echo '%[1]s ALL=(ALL) NOPASSWD:ALL' > %[2]s.tmp && chmod 440 %[2]s.tmp`, driver.SSHUser, sudoersFile,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, fmt.Sprintf("validate '%s'", sudoersFile), fmt.Sprintf(
+		`PATH="$PATH:/usr/sbin:/sbin" visudo -cf %[1]s.tmp && mv -f %[1]s.tmp %[1]s || (rm -f %[1]s.tmp; false)`, sudoersFile,
+	))
+	if err != nil {
+		return err
+	}
+
+	// Older images don't include /etc/sudoers.d by default.
+	return runSSHCommand(client, "include '/etc/sudoers.d' in sudo configuration",
+		`grep -Eq '^[#@]includedir[[:space:]]+/etc/sudoers.d' /etc/sudoers || echo '#includedir /etc/sudoers.d' >> /etc/sudoers`,
+	)
+}
+
+// Lock the bootstrap user's password and disable password authentication in sshd.
+func (driver *Driver) hardenSSHServer(client *sshSession) error {
+	err := runSSHCommand(client, fmt.Sprintf("lock password for '%s'", sshBootstrapUser), fmt.Sprintf(
+		"passwd -l %s", sshBootstrapUser,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, "back up sshd configuration", fmt.Sprintf(
+		"cp -p %s %s", sshdConfigFile, sshdConfigBackupFile,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, "disable SSH password authentication",
+		setSSHDConfigOptionCommand("PasswordAuthentication", "no"),
+	)
+	if err != nil {
+		return err
+	}
+
+	if driver.SSHPort != sshDefaultPort {
+		err = driver.relocateSSHServer(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	if driver.SSHUser != sshBootstrapUser {
+		err = runSSHCommand(client, "disable SSH root login",
+			setSSHDConfigOptionCommand("PermitRootLogin", "no"),
+		)
+		if err != nil {
+			return err
+		}
+	} else if driver.SSHRestrictRootLogin {
+		err = runSSHCommand(client, "restrict SSH root login",
+			setSSHDConfigOptionCommand("PermitRootLogin", "prohibit-password"),
 		)
+		if err != nil {
+			return err
+		}
 	}
 
-	log.Debugf("Secure '$HOME/.ssh'...")
-	output, err = client.Output(`chmod 700 "$HOME/.ssh"`)
+	err = runSSHCommand(client, "validate sshd configuration",
+		`PATH="$PATH:/usr/sbin:/sbin" sshd -t`,
+	)
 	if err != nil {
-		return fmt.Errorf("Failed to secure '$HOME/.ssh'\n%s\nOutput:\n%s",
-			err.Error(),
-			output,
+		rollbackErr := driver.rollBackSSHServerHardening(client)
+		if rollbackErr != nil {
+			log.Errorf("Failed to roll back sshd configuration: %s", rollbackErr.Error())
+		}
+
+		return err
+	}
+
+	return runSSHCommand(client, "reload sshd", reloadSSHDCommand)
+}
+
+// Configure sshd to listen on the configured SSH port (in addition to, or instead of, the default port).
+//
+// On RHEL-family images, the port is also added to SELinux policy and firewalld (if they are enabled).
+func (driver *Driver) relocateSSHServer(client *sshSession) error {
+	ports := []string{
+		strconv.Itoa(driver.SSHPort),
+	}
+	if driver.SSHKeepDefaultPort {
+		ports = append(ports,
+			strconv.Itoa(sshDefaultPort),
 		)
 	}
 
-	log.Debugf("Add SSH key to '$HOME/.ssh/authorized_keys'...")
-	publicKey, err := driver.getSSHPublicKey()
+	err := runSSHCommand(client, fmt.Sprintf("configure sshd to listen on port(s) %s", strings.Join(ports, ", ")),
+		setSSHDConfigOptionCommand("Port", ports...),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = runSSHCommand(client, fmt.Sprintf("allow sshd to listen on port %d (SELinux)", driver.SSHPort), fmt.Sprintf(
+		`if command -v selinuxenabled >/dev/null 2>&1 && selinuxenabled; then semanage port -a -t ssh_port_t -p tcp %[1]d 2>/dev/null || semanage port -m -t ssh_port_t -p tcp %[1]d; fi`,
+		driver.SSHPort,
+	))
 	if err != nil {
 		return err
 	}
-	output, err = client.Output(fmt.Sprintf(
-		`echo '%s' >> "$HOME/.ssh/authorized_keys"`, publicKey,
+
+	return runSSHCommand(client, fmt.Sprintf("allow inbound traffic on port %d (firewalld)", driver.SSHPort), fmt.Sprintf(
+		`if command -v firewall-cmd >/dev/null 2>&1 && firewall-cmd --state >/dev/null 2>&1; then firewall-cmd --permanent --add-port=%[1]d/tcp && firewall-cmd --add-port=%[1]d/tcp; fi`,
+		driver.SSHPort,
+	))
+}
+
+// Restore the original sshd configuration (and unlock the bootstrap user's password).
+func (driver *Driver) rollBackSSHServerHardening(client *sshSession) error {
+	err := runSSHCommand(client, "restore sshd configuration", fmt.Sprintf(
+		"if [ -f %[2]s ]; then mv -f %[2]s %[1]s; fi", sshdConfigFile, sshdConfigBackupFile,
 	))
 	if err != nil {
-		return fmt.Errorf("Failed to add SSH key to '$HOME/.ssh/authorized_keys'\n%s\nOutput:\n%s",
-			err.Error(),
-			output,
+		return err
+	}
+
+	err = runSSHCommand(client, "reload sshd", reloadSSHDCommand)
+	if err != nil {
+		return err
+	}
+
+	return runSSHCommand(client, fmt.Sprintf("unlock password for '%s'", sshBootstrapUser), fmt.Sprintf(
+		"passwd -u %s", sshBootstrapUser,
+	))
+}
+
+// Verify that we can connect to the target server on the specified port using key-based authentication.
+func (driver *Driver) verifySSHKeyAuthentication(port int) error {
+	keyConfig, err := driver.newSSHKeyAuthConfig()
+	if err != nil {
+		return err
+	}
+
+	var (
+		client      *sshSession
+		notReadyErr *SSHNotReadyError
+	)
+	for attempt := 1; attempt <= sshVerifyAttempts; attempt++ {
+		client, notReadyErr = driver.probeSSH(port, keyConfig)
+		if notReadyErr == nil || notReadyErr.Reason == sshNotReadyAuthFailed {
+			break
+		}
+
+		time.Sleep(sshReadyInitialDelay)
+	}
+	if notReadyErr != nil {
+		return fmt.Errorf("Unable to connect to '%s:%d' as '%s' using %s: %s",
+			driver.IPAddress,
+			port,
+			driver.SSHUser,
+			driver.describeSSHKey(),
+			notReadyErr.Error(),
 		)
 	}
+	defer client.Close()
+
+	return runSSHCommand(client, "run command using key-based SSH authentication", "true")
+}
 
-	log.Debugf("Secure '$HOME/.ssh/authorized_keys'...")
-	output, err = client.Output(`chmod 600 "$HOME/.ssh/authorized_keys"`)
+// Verify that the target server's sshd accepts keys of the same type as the specified public key.
+func checkSSHKeyTypeAccepted(client *sshSession, publicKey string) error {
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(publicKey))
 	if err != nil {
-		return fmt.Errorf("Failed to secure '$HOME/.ssh/authorized_keys'\n%s\nOutput:\n%s",
-			err.Error(),
-			output,
+		return fmt.Errorf("Unable to parse SSH public key: %s", err.Error())
+	}
+
+	// Older versions of sshd use PubkeyAcceptedKeyTypes rather than PubkeyAcceptedAlgorithms; very old versions don't report either.
+	output, err := client.Output(
+		`PATH="$PATH:/usr/sbin:/sbin" sshd -T 2>/dev/null | grep -Ei '^pubkeyaccepted(algorithms|keytypes) ' | cut -d' ' -f2`,
+	)
+	acceptedAlgorithms := strings.TrimSpace(output)
+	if err != nil || acceptedAlgorithms == "" {
+		output, err = client.Output("ssh -Q key 2>/dev/null | paste -sd, -")
+		acceptedAlgorithms = strings.TrimSpace(output)
+	}
+	if err != nil || acceptedAlgorithms == "" {
+		log.Warnf("Unable to determine which SSH key types are accepted by the target server; assuming '%s' is supported.", key.Type())
+
+		return nil
+	}
+
+	if !sshdAcceptsKeyType(acceptedAlgorithms, key.Type()) {
+		return fmt.Errorf("The target server's SSH daemon does not accept '%s' keys (accepted key types are '%s'); try a different value for --ddcloud-ssh-key-type",
+			key.Type(),
+			acceptedAlgorithms,
 		)
 	}
 
-	log.Debugf("Remove password for '%s'...", driver.SSHUser)
-	output, err = client.Output(fmt.Sprintf(
-		"passwd -d %s", driver.SSHUser,
+	return nil
+}
+
+// Get the home directory of the specified user on the target server.
+func getUserHomeDirectory(client *sshSession, userName string) (string, error) {
+	output, err := client.Output(fmt.Sprintf(
+		"getent passwd %s | cut -d: -f6", userName,
 	))
 	if err != nil {
-		return fmt.Errorf("Failed to remove initial password for '%s'\n%s\nOutput:\n%s",
-			driver.SSHUser,
+		return "", fmt.Errorf("Failed to determine home directory for '%s'\n%s\nOutput:\n%s",
+			userName,
 			err.Error(),
 			output,
 		)
 	}
 
-	driver.SSHBootstrapPassword = ""
+	homeDirectory := strings.TrimSpace(output)
+	if homeDirectory == "" {
+		return "", fmt.Errorf("User '%s' does not exist on the target server", userName)
+	}
 
-	log.Debugf("SSH bootstrap process complete; the public key from '%s' is now installed on host '%s:%d' for user '%s'.",
-		driver.SSHKeyPath+".pub",
-		driver.IPAddress,
-		driver.SSHPort,
-		driver.SSHUser,
-	)
+	return homeDirectory, nil
+}
+
+// Command to reload sshd (service name and init system vary between distributions).
+const reloadSSHDCommand = "systemctl reload sshd 2>/dev/null || systemctl reload ssh 2>/dev/null || service sshd reload 2>/dev/null || service ssh reload"
+
+// Build a command that sets an option in the sshd configuration file.
+//
+// sshd uses the first value it finds for most options, so existing occurrences are commented out and the new value(s) are inserted at the top of the file
+// (before any Include or Match directives).
+func setSSHDConfigOptionCommand(option string, values ...string) string {
+	command := fmt.Sprintf(`sed -i -E -e 's/^([[:space:]]*%s[[:space:]])/#\1/I'`, option)
+	for _, value := range values {
+		command += fmt.Sprintf(` -e '1i %s %s'`, option, value)
+	}
+
+	return command + " " + sshdConfigFile
+}
+
+// Run a command over SSH, returning a descriptive error if it fails.
+func runSSHCommand(client *sshSession, description string, command string) error {
+	log.Debugf("Running command to %s...", description)
+
+	output, err := client.Output(command)
+	if err != nil {
+		return fmt.Errorf("Failed to %s\n%s\nOutput:\n%s",
+			description,
+			err.Error(),
+			output,
+		)
+	}
 
 	return nil
 }
@@ -112,8 +458,10 @@ func (driver *Driver) generateSSHKey() error {
 		return errors.New("SSH key path already configured")
 	}
 
-	driver.SSHKeyPath = driver.ResolveStorePath("id_rsa")
-	err := ssh.GenerateSSHKey(driver.SSHKeyPath)
+	driver.SSHKeyPath = driver.ResolveStorePath(
+		sshKeyFileName(driver.SSHKeyType),
+	)
+	err := generateSSHKeyPair(driver.SSHKeyType, driver.SSHKeyPath)
 	if err != nil {
 		log.Errorf("Failed to generate SSH key pair: %s", err.Error())
 
@@ -139,18 +487,58 @@ func (driver *Driver) importSSHKey() error {
 		return err
 	}
 
-	err = copySSHKey(driver.SSHKey+".pub", driver.SSHKeyPath+".pub")
-	if err != nil {
-		log.Infof("Couldn't copy SSH public key: %s", err.Error())
+	_, err = os.Stat(driver.SSHKey + ".pub")
+	if err == nil {
+		err = copySSHKey(driver.SSHKey+".pub", driver.SSHKeyPath+".pub")
+		if err != nil {
+			log.Infof("Couldn't copy SSH public key: %s", err.Error())
 
+			return err
+		}
+
+		return nil
+	}
+	if !os.IsNotExist(err) {
 		return err
 	}
 
-	return nil
+	log.Infof("SSH public key '%s' not found; deriving it from the private key...", driver.SSHKey+".pub")
+	signer, err := parseSSHPrivateKey(driver.SSHKeyPath)
+	if err != nil {
+		return err
+	}
+
+	return writeSSHPublicKey(signer.PublicKey(), driver.SSHKeyPath+".pub")
+}
+
+// Create SSH client configuration for key-based authentication as the target user (using either the SSH key file or ssh-agent).
+func (driver *Driver) newSSHKeyAuthConfig() (*gossh.ClientConfig, error) {
+	if driver.SSHUseAgent {
+		return driver.newSSHAgentConfig(driver.SSHUser, driver.sshHostKeyCallback())
+	}
+
+	return newSSHKeyConfig(driver.SSHUser, driver.SSHKeyPath, driver.sshHostKeyCallback())
+}
+
+// Describe the configured SSH key (for logging purposes).
+func (driver *Driver) describeSSHKey() string {
+	if driver.SSHUseAgent {
+		return "ssh-agent identity"
+	}
+
+	return fmt.Sprintf("SSH key '%s'", driver.SSHKeyPath)
 }
 
 // Get the public portion of the configured SSH key.
 func (driver *Driver) getSSHPublicKey() (string, error) {
+	if driver.SSHUseAgent {
+		if driver.SSHAgentPublicKey == "" {
+			return "", errors.New("ssh-agent identity has not been resolved")
+		}
+
+		return driver.SSHAgentPublicKey, nil
+	}
+
 	publicKeyFile, err := os.Open(driver.SSHKeyPath + ".pub")
 	if err != nil {
 		return "", err
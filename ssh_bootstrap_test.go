@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetSSHDConfigOptionCommand(t *testing.T) {
+	testCases := []struct {
+		Option          string
+		Values          []string
+		ExpectedCommand string
+	}{
+		{
+			"PasswordAuthentication", []string{"no"},
+			`sed -i -E -e 's/^([[:space:]]*PasswordAuthentication[[:space:]])/#\1/I' -e '1i PasswordAuthentication no' /etc/ssh/sshd_config`,
+		},
+		{
+			"Port", []string{"22", "2222"},
+			`sed -i -E -e 's/^([[:space:]]*Port[[:space:]])/#\1/I' -e '1i Port 22' -e '1i Port 2222' /etc/ssh/sshd_config`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		command := setSSHDConfigOptionCommand(testCase.Option, testCase.Values...)
+		if command != testCase.ExpectedCommand {
+			t.Errorf("Command to set '%s' is:\n%s\n(expected:\n%s)", testCase.Option, command, testCase.ExpectedCommand)
+		}
+	}
+}
+
+const testSSHDConfig = `# Example sshd configuration.
+Include /etc/ssh/sshd_config.d/*.conf
+#PermitRootLogin prohibit-password
+passwordauthentication yes
+  PasswordAuthentication yes
+PasswordAuthenticationFoo yes
+Match User docker
+	PasswordAuthentication yes
+`
+
+const expectedSSHDConfig = `PermitRootLogin no
+PasswordAuthentication no
+# Example sshd configuration.
+Include /etc/ssh/sshd_config.d/*.conf
+#PermitRootLogin prohibit-password
+#passwordauthentication yes
+#  PasswordAuthentication yes
+PasswordAuthenticationFoo yes
+Match User docker
+#	PasswordAuthentication yes
+`
+
+func TestSetSSHDConfigOptionCommandEditsConfig(t *testing.T) {
+	_, err := exec.LookPath("sed")
+	if err != nil {
+		t.Skip("sed is not available")
+	}
+
+	configFile := filepath.Join(t.TempDir(), "sshd_config")
+	err = ioutil.WriteFile(configFile, []byte(testSSHDConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An option that already exists (in any case, and with leading whitespace) is commented out, and one that is commented out is left as-is.
+	for _, command := range []string{
+		setSSHDConfigOptionCommand("PasswordAuthentication", "no"),
+		setSSHDConfigOptionCommand("PermitRootLogin", "no"),
+	} {
+		command = strings.TrimSuffix(command, sshdConfigFile) + configFile
+
+		output, err := exec.Command("sh", "-c", command).CombinedOutput()
+		if err != nil {
+			t.Skipf("sed does not support the command (%s): %s", err, output)
+		}
+	}
+
+	config, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(config) != expectedSSHDConfig {
+		t.Fatalf("Edited configuration is:\n%s\n(expected:\n%s)", config, expectedSSHDConfig)
+	}
+}
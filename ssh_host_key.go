@@ -0,0 +1,185 @@
+package main
+
+/*
+ * SSH host key pinning
+ * --------------------
+ *
+ * The target server's host key is recorded the first time the driver connects to it (optionally verifying it against a fingerprint supplied by the user).
+ * All subsequent connections made by the driver must present the same key, and the key is written to a known_hosts file in the machine store folder
+ * (for each address at which SSH sessions connect to the server).
+ *
+ * Docker Machine's own SSH clients (e.g. for "docker-machine ssh" and provisioning) ignore host keys, so before returning the SSH address to Docker Machine,
+ * the driver connects to it and verifies the host key presented there against the known_hosts file.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The name of the known_hosts file in the machine store folder.
+const knownHostsFileName = "known_hosts"
+
+// Create a callback that verifies (or, on first connection, records) the target server's SSH host key.
+func (driver *Driver) sshHostKeyCallback() gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		fingerprint := gossh.FingerprintSHA256(key)
+
+		if driver.SSHHostKey != "" {
+			pinnedKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(driver.SSHHostKey))
+			if err != nil {
+				return fmt.Errorf("Unable to parse pinned SSH host key for server '%s': %s", driver.MachineName, err.Error())
+			}
+
+			if !bytes.Equal(pinnedKey.Marshal(), key.Marshal()) {
+				return fmt.Errorf("SSH host key for '%s' has changed (expected '%s', but server presented '%s'); this may indicate that someone is intercepting your connection",
+					hostname,
+					gossh.FingerprintSHA256(pinnedKey),
+					fingerprint,
+				)
+			}
+
+			return nil
+		}
+
+		if driver.SSHHostKeyFingerprint != "" && !matchesSSHFingerprint(key, driver.SSHHostKeyFingerprint) {
+			return fmt.Errorf("SSH host key for '%s' does not match the expected fingerprint (expected '%s', but server presented '%s')",
+				hostname,
+				driver.SSHHostKeyFingerprint,
+				fingerprint,
+			)
+		}
+
+		driver.SSHHostKey = strings.TrimSpace(
+			string(gossh.MarshalAuthorizedKey(key)),
+		)
+		log.Infof("Recorded SSH host key for server '%s' (%s %s).", driver.MachineName, key.Type(), fingerprint)
+
+		return nil
+	}
+}
+
+// Write the pinned SSH host key to the known_hosts file in the machine store folder.
+//
+// The file lists the server's own SSH address, as well as any other addresses (e.g. the local end of a port-forward via an SSH bastion) at which SSH sessions connect to it.
+func (driver *Driver) writeKnownHostsFile(sessionAddresses ...string) error {
+	if driver.SSHHostKey == "" {
+		return errors.New("SSH host key has not been recorded")
+	}
+
+	hostKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(driver.SSHHostKey))
+	if err != nil {
+		return err
+	}
+
+	addresses := []string{
+		knownhosts.Normalize(net.JoinHostPort(driver.IPAddress, strconv.Itoa(driver.SSHPort))),
+	}
+	for _, sessionAddress := range sessionAddresses {
+		sessionAddress = knownhosts.Normalize(sessionAddress)
+		if sessionAddress != addresses[0] {
+			addresses = append(addresses, sessionAddress)
+		}
+	}
+	knownHostsLine := knownhosts.Line(addresses, hostKey) + "\n"
+
+	knownHostsFile := driver.ResolveStorePath(knownHostsFileName)
+	err = ioutil.WriteFile(knownHostsFile, []byte(knownHostsLine), 0600)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Wrote SSH host key for '%s' to '%s'.", strings.Join(addresses, "', '"), knownHostsFile)
+
+	return nil
+}
+
+// Verify that the SSH host key presented at the specified address (where an SSH session is about to connect to the target server) matches the pinned host key.
+//
+// The address is added to the known_hosts file, and the key presented there is then verified against that file.
+// Machines created before host keys were pinned are not verified.
+func (driver *Driver) verifySSHHostKey(host string, port int) error {
+	if driver.SSHHostKey == "" {
+		log.Debugf("No SSH host key has been pinned for server '%s'; its host key will not be verified.", driver.MachineName)
+
+		return nil
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	if address == driver.verifiedSSHHostKeyAddress {
+		return nil
+	}
+
+	err := driver.writeKnownHostsFile(address)
+	if err != nil {
+		return err
+	}
+
+	knownHostsFile := driver.ResolveStorePath(knownHostsFileName)
+	verifyKnownHost, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read '%s': %s", knownHostsFile, err.Error())
+	}
+
+	// We only need the key exchange to complete (so the host key is verified), not authentication.
+	var hostKeyErr error
+	hostKeyVerified := false
+	config := &gossh.ClientConfig{
+		User: driver.SSHUser,
+		HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			hostKeyErr = verifyKnownHost(hostname, remote, key)
+			if hostKeyErr != nil {
+				hostKeyErr = fmt.Errorf("SSH host key presented at '%s' (%s) does not match the pinned host key for server '%s' in '%s'; this may indicate that someone is intercepting your connection",
+					address,
+					gossh.FingerprintSHA256(key),
+					driver.MachineName,
+					knownHostsFile,
+				)
+
+				return hostKeyErr
+			}
+			hostKeyVerified = true
+
+			return nil
+		},
+		Timeout: sshConnectTimeout,
+	}
+	client, err := gossh.Dial("tcp", address, config)
+	if err == nil {
+		client.Close()
+	}
+
+	if hostKeyErr != nil {
+		return hostKeyErr
+	}
+	if !hostKeyVerified {
+		return fmt.Errorf("Unable to verify SSH host key for server '%s' at '%s': %s", driver.MachineName, address, err)
+	}
+
+	log.Debugf("Verified SSH host key for server '%s' at '%s'.", driver.MachineName, address)
+	driver.verifiedSSHHostKeyAddress = address
+
+	return nil
+}
+
+// Determine whether an SSH public key matches the specified fingerprint ("SHA256:xxx", "MD5:xx:xx:...", or legacy "xx:xx:..." format).
+func matchesSSHFingerprint(key gossh.PublicKey, fingerprint string) bool {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return gossh.FingerprintSHA256(key) == fingerprint
+	}
+
+	fingerprint = strings.TrimPrefix(fingerprint, "MD5:")
+
+	return strings.EqualFold(gossh.FingerprintLegacyMD5(key), fingerprint)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Generate a new SSH host key.
+func newTestSSHHostKey(t *testing.T) gossh.Signer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// Format an SSH public key as it is pinned in the driver configuration.
+func formatPinnedSSHHostKey(key gossh.PublicKey) string {
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
+}
+
+func TestMatchesSSHFingerprint(t *testing.T) {
+	key := newTestSSHHostKey(t).PublicKey()
+	otherKey := newTestSSHHostKey(t).PublicKey()
+
+	testCases := []struct {
+		Name        string
+		Fingerprint string
+		Expected    bool
+	}{
+		{"SHA256", gossh.FingerprintSHA256(key), true},
+		{"SHA256 with whitespace", " " + gossh.FingerprintSHA256(key) + "\n", true},
+		{"SHA256 of other key", gossh.FingerprintSHA256(otherKey), false},
+		{"SHA256 in wrong case", "SHA256:" + strings.ToUpper(strings.TrimPrefix(gossh.FingerprintSHA256(key), "SHA256:")), false},
+		{"MD5", "MD5:" + gossh.FingerprintLegacyMD5(key), true},
+		{"Legacy MD5", gossh.FingerprintLegacyMD5(key), true},
+		{"Legacy MD5 in upper case", strings.ToUpper(gossh.FingerprintLegacyMD5(key)), true},
+		{"MD5 of other key", "MD5:" + gossh.FingerprintLegacyMD5(otherKey), false},
+		{"Empty", "", false},
+	}
+
+	for _, testCase := range testCases {
+		if matchesSSHFingerprint(key, testCase.Fingerprint) != testCase.Expected {
+			t.Errorf("%s: fingerprint '%s' match is %t (expected %t)", testCase.Name, testCase.Fingerprint, !testCase.Expected, testCase.Expected)
+		}
+	}
+}
+
+func TestSSHHostKeyCallbackPinsKeyOnFirstUse(t *testing.T) {
+	key := newTestSSHHostKey(t).PublicKey()
+	driver := &Driver{BaseDriver: &drivers.BaseDriver{MachineName: "test-machine"}}
+
+	callback := driver.sshHostKeyCallback()
+	err := callback("203.0.113.10:22", nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.SSHHostKey != formatPinnedSSHHostKey(key) {
+		t.Fatalf("Pinned host key is '%s' (expected '%s')", driver.SSHHostKey, formatPinnedSSHHostKey(key))
+	}
+
+	// Subsequent connections must present the same key.
+	err = callback("203.0.113.10:22", nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = callback("203.0.113.10:22", nil, newTestSSHHostKey(t).PublicKey())
+	if err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Fatalf("Expected host key mismatch error (got %v)", err)
+	}
+	if driver.SSHHostKey != formatPinnedSSHHostKey(key) {
+		t.Fatal("Pinned host key was replaced by a different key")
+	}
+}
+
+func TestSSHHostKeyCallbackVerifiesExpectedFingerprint(t *testing.T) {
+	key := newTestSSHHostKey(t).PublicKey()
+
+	driver := &Driver{
+		BaseDriver:            &drivers.BaseDriver{MachineName: "test-machine"},
+		SSHHostKeyFingerprint: gossh.FingerprintSHA256(newTestSSHHostKey(t).PublicKey()),
+	}
+	err := driver.sshHostKeyCallback()("203.0.113.10:22", nil, key)
+	if err == nil || !strings.Contains(err.Error(), "does not match the expected fingerprint") {
+		t.Fatalf("Expected fingerprint mismatch error (got %v)", err)
+	}
+	if driver.SSHHostKey != "" {
+		t.Fatal("Host key that did not match the expected fingerprint was pinned")
+	}
+
+	driver.SSHHostKeyFingerprint = gossh.FingerprintSHA256(key)
+	err = driver.sshHostKeyCallback()("203.0.113.10:22", nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver.SSHHostKey != formatPinnedSSHHostKey(key) {
+		t.Fatal("Host key that matched the expected fingerprint was not pinned")
+	}
+}
+
+// Start an SSH server (that rejects all clients once they have verified its host key) which presents the specified host key.
+func startTestSSHServer(t *testing.T, hostKey gossh.Signer) *net.TCPAddr {
+	t.Helper()
+
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+			return nil, errors.New("Access denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				serverConn, _, _, err := gossh.NewServerConn(conn, config)
+				if err == nil {
+					serverConn.Close()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestVerifySSHHostKey(t *testing.T) {
+	hostKey := newTestSSHHostKey(t)
+	address := startTestSSHServer(t, hostKey)
+
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: "test-machine",
+			StorePath:   t.TempDir(),
+			IPAddress:   "203.0.113.10",
+			SSHUser:     "docker",
+			SSHPort:     22,
+		},
+		SSHHostKey: formatPinnedSSHHostKey(hostKey.PublicKey()),
+	}
+	err := os.MkdirAll(driver.ResolveStorePath("."), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The session address (e.g. the local end of a port-forward via an SSH bastion) differs from the server's address.
+	err = driver.verifySSHHostKey(address.IP.String(), address.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts, err := ioutil.ReadFile(driver.ResolveStorePath(knownHostsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPatterns := strings.Fields(string(knownHosts))[0]
+	expectedHostPatterns := fmt.Sprintf("203.0.113.10,[127.0.0.1]:%d", address.Port) // Port 22 is implied.
+	if hostPatterns != expectedHostPatterns {
+		t.Fatalf("known_hosts lists '%s' (expected '%s')", hostPatterns, expectedHostPatterns)
+	}
+
+	// A server presenting a different host key is rejected.
+	otherAddress := startTestSSHServer(t, newTestSSHHostKey(t))
+	err = driver.verifySSHHostKey(otherAddress.IP.String(), otherAddress.Port)
+	if err == nil || !strings.Contains(err.Error(), "does not match the pinned host key") {
+		t.Fatalf("Expected host key mismatch error (got %v)", err)
+	}
+}
+
+func TestVerifySSHHostKeySkipsMachinesWithoutPinnedKey(t *testing.T) {
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: "test-machine",
+			StorePath:   t.TempDir(),
+			IPAddress:   "203.0.113.10",
+			SSHPort:     22,
+		},
+	}
+
+	// Nothing is listening on port 1, so any attempt to connect would fail.
+	err := driver.verifySSHHostKey("127.0.0.1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
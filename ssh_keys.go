@@ -0,0 +1,177 @@
+package main
+
+/*
+ * SSH key generation and parsing
+ * -------------------------------
+ */
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Supported SSH key types (for generated keys).
+const (
+	// 4096-bit RSA key.
+	SSHKeyTypeRSA4096 = "rsa-4096"
+
+	// ECDSA key (NIST P-256).
+	SSHKeyTypeECDSA = "ecdsa"
+
+	// Ed25519 key.
+	SSHKeyTypeED25519 = "ed25519"
+)
+
+// DefaultSSHKeyType is the default type for generated SSH keys.
+const DefaultSSHKeyType = SSHKeyTypeRSA4096
+
+// The environment variable used to supply the passphrase for an encrypted SSH private key.
+const sshKeyPassphraseEnvVar = "MCP_SSH_KEY_PASSPHRASE"
+
+// Is the specified SSH key type supported?
+func isSupportedSSHKeyType(keyType string) bool {
+	switch keyType {
+	case SSHKeyTypeRSA4096, SSHKeyTypeECDSA, SSHKeyTypeED25519:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get the conventional private key file name for the specified SSH key type.
+func sshKeyFileName(keyType string) string {
+	switch keyType {
+	case SSHKeyTypeECDSA:
+		return "id_ecdsa"
+	case SSHKeyTypeED25519:
+		return "id_ed25519"
+	default:
+		return "id_rsa"
+	}
+}
+
+// Generate a new SSH key pair of the specified type, writing the private key to privateKeyFile and the public key to privateKeyFile + ".pub".
+func generateSSHKeyPair(keyType string, privateKeyFile string) error {
+	var (
+		privateKey crypto.PrivateKey
+		err        error
+	)
+	switch keyType {
+	case SSHKeyTypeRSA4096:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	case SSHKeyTypeECDSA:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SSHKeyTypeED25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("Unsupported SSH key type '%s'", keyType)
+	}
+	if err != nil {
+		return err
+	}
+
+	privateKeyPEM, err := gossh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(privateKeyFile, pem.EncodeToMemory(privateKeyPEM), 0600)
+	if err != nil {
+		return err
+	}
+
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	return writeSSHPublicKey(signer.PublicKey(), privateKeyFile+".pub")
+}
+
+// Write an SSH public key to a file (in authorized_keys format).
+func writeSSHPublicKey(publicKey gossh.PublicKey, publicKeyFile string) error {
+	return ioutil.WriteFile(publicKeyFile, gossh.MarshalAuthorizedKey(publicKey), 0644)
+}
+
+// Parse an SSH private key file, obtaining its passphrase (if required) from the environment or the terminal.
+func parseSSHPrivateKey(privateKeyFile string) (gossh.Signer, error) {
+	privateKeyData, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := gossh.ParsePrivateKey(privateKeyData)
+	if _, ok := err.(*gossh.PassphraseMissingError); ok {
+		var passphrase []byte
+		passphrase, err = getSSHKeyPassphrase(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err = gossh.ParsePrivateKeyWithPassphrase(privateKeyData, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse SSH private key '%s': %s", privateKeyFile, err.Error())
+	}
+
+	return signer, nil
+}
+
+// Get the passphrase for an encrypted SSH private key.
+func getSSHKeyPassphrase(privateKeyFile string) ([]byte, error) {
+	passphrase := os.Getenv(sshKeyPassphraseEnvVar)
+	if passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return nil, fmt.Errorf("SSH private key '%s' is encrypted; supply its passphrase via the %s environment variable",
+			privateKeyFile,
+			sshKeyPassphraseEnvVar,
+		)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for SSH key '%s': ", path.Base(privateKeyFile))
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(stdin)
+}
+
+// Determine whether an sshd algorithm list (e.g. the value of PubkeyAcceptedAlgorithms) accepts the specified key type.
+func sshdAcceptsKeyType(acceptedAlgorithms string, keyType string) bool {
+	candidates := []string{keyType}
+	if keyType == gossh.KeyAlgoRSA {
+		// Modern sshd only accepts RSA keys via SHA-2 signatures.
+		candidates = append(candidates, gossh.KeyAlgoRSASHA256, gossh.KeyAlgoRSASHA512)
+	}
+
+	for _, pattern := range strings.Split(acceptedAlgorithms, ",") {
+		for _, candidate := range candidates {
+			matched, err := path.Match(strings.TrimSpace(pattern), candidate)
+			if err != nil {
+				log.Debugf("Ignoring invalid sshd algorithm pattern '%s'.", pattern)
+
+				continue
+			}
+			if matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
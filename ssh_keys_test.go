@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestGenerateSSHKeyPair(t *testing.T) {
+	testCases := []struct {
+		KeyType         string
+		ExpectedKeyType string
+		ExpectedBits    int
+	}{
+		{SSHKeyTypeRSA4096, gossh.KeyAlgoRSA, 4096},
+		{SSHKeyTypeECDSA, gossh.KeyAlgoECDSA256, 0},
+		{SSHKeyTypeED25519, gossh.KeyAlgoED25519, 0},
+	}
+
+	for _, testCase := range testCases {
+		privateKeyFile := filepath.Join(t.TempDir(), sshKeyFileName(testCase.KeyType))
+		err := generateSSHKeyPair(testCase.KeyType, privateKeyFile)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.KeyType, err)
+		}
+
+		signer, err := parseSSHPrivateKey(privateKeyFile)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.KeyType, err)
+		}
+		if signer.PublicKey().Type() != testCase.ExpectedKeyType {
+			t.Errorf("%s: generated key of type '%s' (expected '%s')", testCase.KeyType, signer.PublicKey().Type(), testCase.ExpectedKeyType)
+		}
+		if testCase.ExpectedBits != 0 {
+			cryptoPublicKey := signer.PublicKey().(gossh.CryptoPublicKey).CryptoPublicKey()
+			bits := cryptoPublicKey.(interface{ Size() int }).Size() * 8
+			if bits != testCase.ExpectedBits {
+				t.Errorf("%s: generated %d-bit key (expected %d bits)", testCase.KeyType, bits, testCase.ExpectedBits)
+			}
+		}
+
+		publicKeyData, err := ioutil.ReadFile(privateKeyFile + ".pub")
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.KeyType, err)
+		}
+		publicKey, _, _, _, err := gossh.ParseAuthorizedKey(publicKeyData)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.KeyType, err)
+		}
+		if !bytes.Equal(publicKey.Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("%s: public key file does not match the private key", testCase.KeyType)
+		}
+
+		fileInfo, err := os.Stat(privateKeyFile)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.KeyType, err)
+		}
+		if runtime.GOOS != "windows" && fileInfo.Mode().Perm()&0077 != 0 {
+			t.Errorf("%s: private key file is accessible to other users (mode %s)", testCase.KeyType, fileInfo.Mode().Perm())
+		}
+	}
+}
+
+func TestGenerateSSHKeyPairRejectsUnsupportedType(t *testing.T) {
+	err := generateSSHKeyPair("dsa", filepath.Join(t.TempDir(), "id_dsa"))
+	if err == nil || !strings.Contains(err.Error(), "Unsupported SSH key type 'dsa'") {
+		t.Fatalf("Expected unsupported key type error (got %v)", err)
+	}
+}
+
+// Write an Ed25519 private key (without a public key file), encrypted with the specified passphrase (if any).
+func writeTestSSHPrivateKey(t *testing.T, privateKeyFile string, passphrase string) gossh.PublicKey {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var privateKeyPEM *pem.Block
+	if passphrase == "" {
+		privateKeyPEM, err = gossh.MarshalPrivateKey(privateKey, "test-key")
+	} else {
+		privateKeyPEM, err = gossh.MarshalPrivateKeyWithPassphrase(privateKey, "test-key", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(privateKeyFile, pem.EncodeToMemory(privateKeyPEM), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshPublicKey, err := gossh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sshPublicKey
+}
+
+func TestImportSSHKeyDerivesPublicKey(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Passphrase string
+	}{
+		{"Unencrypted", ""},
+		{"Encrypted", "correct horse battery staple"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			t.Setenv(sshKeyPassphraseEnvVar, testCase.Passphrase)
+
+			sourceKeyFile := filepath.Join(t.TempDir(), "id_ed25519")
+			expectedPublicKey := writeTestSSHPrivateKey(t, sourceKeyFile, testCase.Passphrase)
+
+			driver := &Driver{
+				BaseDriver: &drivers.BaseDriver{
+					MachineName: "test-machine",
+					StorePath:   t.TempDir(),
+				},
+				SSHKey: sourceKeyFile,
+			}
+			err := os.MkdirAll(driver.ResolveStorePath("."), 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = driver.importSSHKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publicKeyData, err := driver.getSSHPublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			publicKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(publicKeyData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(publicKey.Marshal(), expectedPublicKey.Marshal()) {
+				t.Fatalf("Derived public key '%s' does not match the private key", strings.TrimSpace(publicKeyData))
+			}
+
+			_, err = os.Stat(sourceKeyFile + ".pub")
+			if !os.IsNotExist(err) {
+				t.Fatal("Public key was written next to the source private key (instead of only into the machine store folder)")
+			}
+		})
+	}
+}
+
+func TestImportSSHKeyCopiesExistingPublicKey(t *testing.T) {
+	sourceKeyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	writeTestSSHPrivateKey(t, sourceKeyFile, "")
+
+	// The existing public key file is used as-is (even if it has a comment that cannot be derived from the private key).
+	const existingPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHJlYWQtZnJvbS1leGlzdGluZy1wdWJsaWMta2V5LWZpbGU= user@example\n"
+	err := ioutil.WriteFile(sourceKeyFile+".pub", []byte(existingPublicKey), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := &Driver{
+		BaseDriver: &drivers.BaseDriver{
+			MachineName: "test-machine",
+			StorePath:   t.TempDir(),
+		},
+		SSHKey: sourceKeyFile,
+	}
+	err = os.MkdirAll(driver.ResolveStorePath("."), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = driver.importSSHKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKeyData, err := driver.getSSHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKeyData != existingPublicKey {
+		t.Fatalf("Imported public key is '%s' (expected '%s')", publicKeyData, existingPublicKey)
+	}
+}
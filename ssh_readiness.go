@@ -0,0 +1,185 @@
+package main
+
+/*
+ * Wait for SSH readiness
+ * ----------------------
+ *
+ * Freshly-deployed servers (and newly-created NAT / firewall rules) often take a little while before they accept SSH connections,
+ * so we probe the SSH port (and then attempt an SSH handshake) with exponential back-off until it succeeds or we run out of time.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHReadyTimeout is the default time (in seconds) to wait for the target server to accept SSH connections.
+const DefaultSSHReadyTimeout = 300
+
+// SSH readiness back-off.
+const (
+	// The initial delay between SSH readiness probes.
+	sshReadyInitialDelay = 2 * time.Second
+
+	// The maximum delay between SSH readiness probes.
+	sshReadyMaxDelay = 30 * time.Second
+
+	// The timeout for each TCP reachability probe.
+	sshReadyProbeTimeout = 10 * time.Second
+)
+
+// Reasons why the target server is not (yet) accepting SSH connections.
+const (
+	sshNotReadyNetworkUnreachable = "network unreachable"
+	sshNotReadyPortClosed         = "port closed"
+	sshNotReadyHandshakeFailed    = "handshake failed"
+	sshNotReadyAuthFailed         = "auth failed"
+)
+
+// SSHNotReadyError indicates that the target server did not accept SSH connections before the deadline.
+type SSHNotReadyError struct {
+	// The target address.
+	Address string
+
+	// The reason ("network unreachable", "port closed", "handshake failed", or "auth failed").
+	Reason string
+
+	// The underlying error (from the last attempt).
+	Err error
+}
+
+func (err *SSHNotReadyError) Error() string {
+	return fmt.Sprintf("Server '%s' is not accepting SSH connections (%s): %s", err.Address, err.Reason, err.Err.Error())
+}
+
+// Wait for the target server to accept SSH connections on the specified port, then open a session using the specified configuration.
+func (driver *Driver) waitForSSH(port int, config *gossh.ClientConfig) (*sshSession, error) {
+	timeout := time.Duration(driver.SSHReadyTimeout) * time.Second
+	deadline := time.Now().Add(timeout)
+	address := net.JoinHostPort(driver.IPAddress,
+		strconv.Itoa(port),
+	)
+
+	log.Infof("Waiting up to %s for server '%s' to accept SSH connections on '%s'...", timeout, driver.MachineName, address)
+
+	delay := sshReadyInitialDelay
+	for attempt := 1; ; attempt++ {
+		session, notReadyErr := driver.probeSSH(port, config)
+		if notReadyErr == nil {
+			log.Debugf("Server '%s' accepted SSH connection on '%s' (attempt %d).", driver.MachineName, address, attempt)
+
+			return session, nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return nil, notReadyErr
+		}
+
+		log.Debugf("SSH not ready (attempt %d): %s; retrying in %s...", attempt, notReadyErr.Error(), delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > sshReadyMaxDelay {
+			delay = sshReadyMaxDelay
+		}
+	}
+}
+
+// Probe TCP reachability of the target server's SSH port, then attempt to open an SSH session.
+func (driver *Driver) probeSSH(port int, config *gossh.ClientConfig) (*sshSession, *SSHNotReadyError) {
+	address := net.JoinHostPort(driver.IPAddress,
+		strconv.Itoa(port),
+	)
+	connection, err := driver.dialSSHTargetWithTimeout(address, sshReadyProbeTimeout)
+	if err != nil {
+		return nil, &SSHNotReadyError{
+			Address: address,
+			Reason:  classifyDialError(err),
+			Err:     err,
+		}
+	}
+	connection.Close()
+
+	session, err := driver.openSSHSession(driver.IPAddress, port, config)
+	if err != nil {
+		reason := sshNotReadyHandshakeFailed
+		if isSSHAuthError(err) {
+			reason = sshNotReadyAuthFailed
+		}
+
+		return nil, &SSHNotReadyError{
+			Address: address,
+			Reason:  reason,
+			Err:     err,
+		}
+	}
+
+	return session, nil
+}
+
+// Dial the specified address (via the bastion, if one is configured), giving up after the specified timeout.
+func (driver *Driver) dialSSHTargetWithTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	if !driver.isSSHBastionConfigured() {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	type dialResult struct {
+		connection net.Conn
+		err        error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		connection, err := driver.dialSSHTarget(address)
+		results <- dialResult{connection, err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.connection, result.err
+	case <-time.After(timeout):
+		// Clean up the connection if the dial eventually succeeds.
+		go func() {
+			result := <-results
+			if result.connection != nil {
+				result.connection.Close()
+			}
+		}()
+
+		return nil, fmt.Errorf("Timed out after %s connecting to '%s'", timeout, address)
+	}
+}
+
+// Determine why a TCP connection attempt failed.
+func classifyDialError(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		var errno syscall.Errno
+		if errors.As(opErr.Err, &errno) && isConnectionRefusedErrno(errno) {
+			return sshNotReadyPortClosed
+		}
+
+		return sshNotReadyNetworkUnreachable // e.g. ENETUNREACH, EHOSTUNREACH, or a timeout.
+	}
+
+	// Connections via the bastion report why the bastion could not connect only in the channel rejection message.
+	var openChannelErr *gossh.OpenChannelError
+	if errors.As(err, &openChannelErr) && openChannelErr.Reason == gossh.ConnectionFailed && strings.Contains(strings.ToLower(openChannelErr.Message), "refused") {
+		return sshNotReadyPortClosed
+	}
+
+	return sshNotReadyNetworkUnreachable
+}
+
+// Determine whether an error from the SSH handshake indicates that authentication failed.
+func isSSHAuthError(err error) bool {
+	// golang.org/x/crypto/ssh doesn't expose a specific error type for (client-side) authentication failures, so they can only be identified by their message.
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Get an address on which nothing is listening.
+func getClosedTestAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	return address
+}
+
+func TestClassifyDialError(t *testing.T) {
+	_, refusedErr := net.DialTimeout("tcp", getClosedTestAddress(t), time.Second)
+	if refusedErr == nil {
+		t.Fatal("Connection to closed port succeeded")
+	}
+
+	testCases := []struct {
+		Name           string
+		Err            error
+		ExpectedReason string
+	}{
+		{"Connection refused", refusedErr, sshNotReadyPortClosed},
+		{"Network unreachable", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, sshNotReadyNetworkUnreachable},
+		{"Host unreachable", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, sshNotReadyNetworkUnreachable},
+		{"Bastion connection refused", &gossh.OpenChannelError{Reason: gossh.ConnectionFailed, Message: "Connection refused"}, sshNotReadyPortClosed},
+		{"Bastion connection failed", &gossh.OpenChannelError{Reason: gossh.ConnectionFailed, Message: "No route to host"}, sshNotReadyNetworkUnreachable},
+		{"Bastion forwarding prohibited", &gossh.OpenChannelError{Reason: gossh.Prohibited, Message: "administratively prohibited: open failed (refused by policy)"}, sshNotReadyNetworkUnreachable},
+		{"Timeout", errors.New("Timed out after 10s connecting to '203.0.113.10:22'"), sshNotReadyNetworkUnreachable},
+	}
+
+	for _, testCase := range testCases {
+		reason := classifyDialError(testCase.Err)
+		if reason != testCase.ExpectedReason {
+			t.Errorf("%s: error '%s' classified as '%s' (expected '%s')", testCase.Name, testCase.Err, reason, testCase.ExpectedReason)
+		}
+	}
+}
+
+func TestIsSSHAuthError(t *testing.T) {
+	address := startTestSSHServer(t, newTestSSHHostKey(t))
+
+	// The test server rejects all passwords.
+	_, authErr := gossh.Dial("tcp", address.String(), &gossh.ClientConfig{
+		User:            "docker",
+		Auth:            []gossh.AuthMethod{gossh.Password("incorrect")},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if authErr == nil {
+		t.Fatal("SSH authentication succeeded with incorrect password")
+	}
+	if !isSSHAuthError(authErr) {
+		t.Errorf("Authentication failure '%s' was not recognised", authErr)
+	}
+
+	_, refusedErr := gossh.Dial("tcp", getClosedTestAddress(t), &gossh.ClientConfig{
+		User:            "docker",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if refusedErr == nil {
+		t.Fatal("SSH connection to closed port succeeded")
+	}
+	if isSSHAuthError(refusedErr) {
+		t.Errorf("Connection failure '%s' was recognised as an authentication failure", refusedErr)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+/*
+ * Wait for SSH readiness (non-Windows)
+ * ------------------------------------
+ */
+
+import (
+	"syscall"
+)
+
+// Determine whether a system error indicates that a connection was refused.
+func isConnectionRefusedErrno(errno syscall.Errno) bool {
+	return errno == syscall.ECONNREFUSED
+}
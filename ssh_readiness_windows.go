@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package main
+
+/*
+ * Wait for SSH readiness (Windows)
+ * --------------------------------
+ */
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Determine whether a system error indicates that a connection was refused.
+func isConnectionRefusedErrno(errno syscall.Errno) bool {
+	return errno == windows.WSAECONNREFUSED || errno == syscall.ECONNREFUSED
+}
@@ -0,0 +1,99 @@
+package main
+
+/*
+ * SSH sessions used by the driver
+ * -------------------------------
+ *
+ * libmachine's native SSH client dials a new connection for every command, which makes it unsuitable for the bootstrap process
+ * (we need to keep the password-authenticated connection open while we verify that key-based authentication still works).
+ */
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// The timeout for establishing SSH connections to the target server.
+const sshConnectTimeout = 30 * time.Second
+
+// A long-lived SSH connection to the target server.
+type sshSession struct {
+	client *gossh.Client
+}
+
+// Open a new SSH session (via the SSH bastion, if one is configured).
+func (driver *Driver) openSSHSession(host string, port int, config *gossh.ClientConfig) (*sshSession, error) {
+	address := net.JoinHostPort(host,
+		strconv.Itoa(port),
+	)
+	connection, err := driver.dialSSHTarget(address)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConnection, channels, requests, err := gossh.NewClientConn(connection, address, config)
+	if err != nil {
+		connection.Close()
+
+		return nil, err
+	}
+
+	return &sshSession{
+		gossh.NewClient(clientConnection, channels, requests),
+	}, nil
+}
+
+// Run a command and return its combined output.
+func (session *sshSession) Output(command string) (string, error) {
+	commandSession, err := session.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer commandSession.Close()
+
+	output, err := commandSession.CombinedOutput(command)
+
+	return string(output), err
+}
+
+// Close the session.
+func (session *sshSession) Close() error {
+	return session.client.Close()
+}
+
+// Create SSH client configuration for password authentication.
+func newSSHPasswordConfig(user string, password string, hostKeyCallback gossh.HostKeyCallback) (*gossh.ClientConfig, error) {
+	if password == "" {
+		return nil, errors.New("SSH bootstrap password has not been configured")
+	}
+
+	return &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{
+			gossh.Password(password),
+		},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	}, nil
+}
+
+// Create SSH client configuration for authentication using the specified private key file.
+func newSSHKeyConfig(user string, privateKeyFile string, hostKeyCallback gossh.HostKeyCallback) (*gossh.ClientConfig, error) {
+	signer, err := parseSSHPrivateKey(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{
+			gossh.PublicKeys(signer),
+		},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	}, nil
+}